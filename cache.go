@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type cacheEntry[V any] struct {
+	value V
+	// fresh until
+	expires time.Time
+}
+
+// cacheCall is a load in progress, concurrent misses of the same key wait for it
+type cacheCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// ttlCache keeps values for ttl and then serves them for another stale
+// period while refreshing in the background. Concurrent loads of the same
+// key are deduplicated. Loads run with their own context derived from base,
+// so a caller giving up doesn't abort the load for others.
+type ttlCache[K comparable, V any] struct {
+	ttl, stale time.Duration
+	base       context.Context
+	timeout    time.Duration
+
+	mu                      sync.Mutex
+	entries                 map[K]cacheEntry[V]
+	calls                   map[K]*cacheCall[V]
+	hits, staleHits, misses uint64
+}
+
+func newTTLCache[K comparable, V any](base context.Context, timeout, ttl, stale time.Duration) *ttlCache[K, V] {
+	return &ttlCache[K, V]{
+		ttl:     ttl,
+		stale:   stale,
+		base:    base,
+		timeout: timeout,
+		entries: make(map[K]cacheEntry[V]),
+		calls:   make(map[K]*cacheCall[V]),
+	}
+}
+
+// Get returns the cached value of key, calling load on miss
+func (c *ttlCache[K, V]) Get(ctx context.Context, key K, load func(context.Context) (V, error)) (V, error) {
+	now := time.Now()
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	switch {
+	case ok && now.Before(entry.expires):
+		c.hits++
+		c.mu.Unlock()
+		return entry.value, nil
+	case ok && now.Before(entry.expires.Add(c.stale)):
+		c.staleHits++
+		if _, running := c.calls[key]; !running {
+			c.startLocked(key, load)
+		}
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	c.misses++
+	call, running := c.calls[key]
+	if !running {
+		call = c.startLocked(key, load)
+	}
+	c.mu.Unlock()
+	select {
+	case <-call.done:
+		return call.value, call.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (c *ttlCache[K, V]) startLocked(key K, load func(context.Context) (V, error)) *cacheCall[V] {
+	call := &cacheCall[V]{done: make(chan struct{})}
+	c.calls[key] = call
+	go func() {
+		ctx, cancel := context.WithTimeout(c.base, c.timeout)
+		call.value, call.err = load(ctx)
+		cancel()
+		c.mu.Lock()
+		if call.err == nil {
+			c.entries[key] = cacheEntry[V]{value: call.value, expires: time.Now().Add(c.ttl)}
+		}
+		delete(c.calls, key)
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	return call
+}
+
+// Prune drops entries which are too old to be served even as stale
+func (c *ttlCache[K, V]) Prune() {
+	now := time.Now()
+	c.mu.Lock()
+	for key, entry := range c.entries {
+		if now.After(entry.expires.Add(c.stale)) {
+			delete(c.entries, key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+func (c *ttlCache[K, V]) Stats() (hits, staleHits, misses uint64, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.staleHits, c.misses, len(c.entries)
+}
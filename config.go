@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"io"
 	"log"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -12,25 +14,53 @@ import (
 
 var (
 	handlers = map[string]optHandler{
-		"nick":           nick,
-		"password":       password,
-		"ident":          ident,
-		"realname":       realName,
-		"weathertoken":   weatherToken,
-		"server":         server,
-		"channels":       channels,
-		"timeout":        timeout,
-		"dbname":         dbname,
-		"useragent":      userAgent,
-		"ignored":        ignored,
-		"admins":         admins,
-		"nickservpass":   nickservPass,
-		"pubfingerprint": pubFingerprint,
+		"nick":             nick,
+		"password":         password,
+		"ident":            ident,
+		"realname":         realName,
+		"weathertoken":     weatherToken,
+		"server":           server,
+		"channels":         channels,
+		"timeout":          timeout,
+		"dbname":           dbname,
+		"useragent":        userAgent,
+		"ignored":          ignored,
+		"admins":           admins,
+		"trusted":          trusted,
+		"channelops":       channelOps,
+		"nickservpass":     nickservPass,
+		"pubfingerprint":   pubFingerprint,
+		"quotemaxlines":    quoteMaxLines,
+		"pastelisten":      pasteListen,
+		"pasteurl":         pasteURL,
+		"qotd":             qotd,
+		"pricealiases":     priceAliases,
+		"priceproviders":   priceProvidersOpt,
+		"weatherproviders": weatherProvidersOpt,
+		"weatherunits":     weatherUnits,
+		"pricewatch":       priceWatch,
+		"priceinterval":    priceInterval,
+		"alertlimit":       alertLimit,
+		"qotdrating":       qotdRating,
+		"qotdhistory":      qotdHistory,
+		"titleallow":       titleAllow,
+		"titlepreview":     titlePreview,
+		"titlesites":       titleSitesOpt,
+	}
+	feedHandlers = map[string]feedOptHandler{
+		"url":      feedURL,
+		"interval": feedInterval,
+		"channels": feedChannels,
+		"prefix":   feedPrefix,
+		"type":     feedType,
+		"summary":  feedSummary,
 	}
 )
 
 type optHandler func(string) option
 type option func(*config)
+type feedOptHandler func(string) feedOption
+type feedOption func(*feedConfig)
 
 type config struct {
 	nick, password, ident     string
@@ -38,8 +68,64 @@ type config struct {
 	server, dbname            string
 	userAgent, nickservPass   string
 	pubFingerprint            string
+	pasteListen, pasteURL     string
 	admins, channels, ignored []string
+	trusted                   []string
 	timeout                   time.Duration
+	quoteMaxLines             int
+	qotd                      []qotdSchedule
+	qotdRating, qotdHistory   int
+	// lowercase channel name to its own QuoteMaxLines
+	channelMaxLines map[string]int
+	// lowercase channel name to accounts managing its news subscriptions
+	channelOps map[string][]string
+	// command name without ! to price symbol
+	priceAliases   map[string]string
+	priceProviders []string
+	// in order of preference
+	weatherProviders []string
+	// lowercase channel name to metric or imperial
+	weatherUnits map[string]string
+	// pairs like BTC/USD sampled into price history
+	priceWatch    []string
+	priceInterval time.Duration
+	alertLimit    int
+	feeds         []*feedConfig
+	// internal host names, addresses and networks titles are fetched from
+	titleAllow []string
+	// lowercase channel name to previewTitle or previewRich
+	titlePreview map[string]string
+	// host patterns to site extractor names, added to the built-in ones
+	titleSites map[string]string
+}
+
+// defaultFeed is polled when the configuration has no [feed] sections,
+// as the bot did before feeds became configurable
+var defaultFeed = feedConfig{
+	name:     "neuralmeduza",
+	url:      "https://t.me/s/neuralmeduza",
+	prefix:   "новости",
+	channels: []string{"#mania"},
+}
+
+// feedConfig is a news source polled into channels
+type feedConfig struct {
+	name, url, prefix string
+	// one of feedTypes
+	kind     string
+	interval time.Duration
+	// lowercase channels subscribed when the feed is seen for the first time,
+	// later managed with !news sub and !news unsub
+	channels []string
+	// runes of item summary to post, 0 posts titles only
+	summary int
+}
+
+type qotdSchedule struct {
+	// lowercase channel name
+	channel string
+	// local wall clock time as hours and minutes since midnight
+	at time.Duration
 }
 
 func loadConfig(fname string) (*config, error) {
@@ -61,14 +147,32 @@ func loadConfig(fname string) (*config, error) {
 func parseConfig(reader io.Reader) (c *config, err error) {
 	scanner := bufio.NewScanner(reader)
 	c = new(config)
+	// directives after a [feed name] header configure that feed
+	var feed *feedConfig
 	for scanner.Scan() {
-		line := scanner.Text()
-		splitted := strings.Split(line, "=")
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			feed = feedSection(c, line)
+			continue
+		}
+		// values like URLs may contain =
+		splitted := strings.SplitN(line, "=", 2)
 		if len(splitted) != 2 {
 			log.Fatalf("Failed to parse: %q", line)
 		}
 		k, v := strings.ToLower(strings.TrimSpace(splitted[0])), strings.TrimSpace(splitted[1])
-		if strings.HasPrefix(k, "#") {
+		if feed != nil {
+			handler, ok := feedHandlers[k]
+			if _, global := handlers[k]; !ok && global {
+				log.Fatalf("Directive %q follows [feed %s], global directives must precede [feed] sections", k, feed.name)
+			}
+			if !ok {
+				log.Fatalf("Unknown directive %q in feed %q", k, feed.name)
+			}
+			handler(v)(feed)
 			continue
 		}
 		handler, ok := handlers[k]
@@ -96,6 +200,48 @@ func parseConfig(reader io.Reader) (c *config, err error) {
 	case c.timeout == time.Duration(0):
 		c.timeout = 10 * time.Second
 	}
+	if c.quoteMaxLines == 0 {
+		c.quoteMaxLines = 6
+	}
+	if c.priceAliases == nil {
+		c.priceAliases = map[string]string{"btc": "BTC", "eth": "ETH", "xmr": "XMR"}
+	}
+	if len(c.priceProviders) == 0 {
+		c.priceProviders = []string{"cryptocompare", "coingecko", "ecb"}
+	}
+	if len(c.weatherProviders) == 0 {
+		if c.weatherToken != "" {
+			c.weatherProviders = append(c.weatherProviders, "openweathermap")
+		}
+		c.weatherProviders = append(c.weatherProviders, "openmeteo")
+	}
+	if c.weatherToken == "" && contains(c.weatherProviders, "openweathermap") {
+		log.Fatalf("WeatherToken must be specified for openweathermap")
+	}
+	if c.priceInterval == 0 {
+		c.priceInterval = 5 * time.Minute
+	}
+	if c.alertLimit == 0 {
+		c.alertLimit = 5
+	}
+	if c.qotdHistory == 0 {
+		c.qotdHistory = 90
+	}
+	if len(c.feeds) == 0 {
+		feed := defaultFeed
+		c.feeds = append(c.feeds, &feed)
+	}
+	for _, feed := range c.feeds {
+		if feed.url == "" {
+			log.Fatalf("URL of feed %q must be specified", feed.name)
+		}
+		if feed.interval == 0 {
+			feed.interval = defaultFeedInterval
+		}
+		if feed.kind == "" {
+			feed.kind = feedAuto
+		}
+	}
 	return c, nil
 
 }
@@ -199,6 +345,33 @@ func admins(value string) option {
 	}
 }
 
+func trusted(value string) option {
+	return func(c *config) {
+		if len(c.trusted) != 0 {
+			log.Fatalf("Repeated Trusted assignment")
+		}
+		c.trusted = strings.Split(value, ",")
+	}
+}
+
+// channelOps parses comma separated list of #channel:account entries
+func channelOps(value string) option {
+	return func(c *config) {
+		if c.channelOps != nil {
+			log.Fatalf("Repeated ChannelOps assignment")
+		}
+		c.channelOps = make(map[string][]string)
+		for _, entry := range splitTrim(value, ",") {
+			splitted := strings.SplitN(entry, ":", 2)
+			if len(splitted) != 2 || !strings.HasPrefix(splitted[0], "#") || splitted[1] == "" {
+				log.Fatalf("%q is not valid ChannelOps entry, expected #channel:account", entry)
+			}
+			channel := strings.ToLower(splitted[0])
+			c.channelOps[channel] = append(c.channelOps[channel], splitted[1])
+		}
+	}
+}
+
 func nickservPass(value string) option {
 	return func(c *config) {
 		if c.nickservPass != "" {
@@ -229,3 +402,342 @@ func timeout(value string) option {
 		c.timeout = time.Duration(n) * time.Second
 	}
 }
+
+// quoteMaxLines parses comma separated list of the default limit and
+// #channel:limit overrides
+func quoteMaxLines(value string) option {
+	return func(c *config) {
+		if c.quoteMaxLines != 0 || c.channelMaxLines != nil {
+			log.Fatalf("Repeated QuoteMaxLines assignment")
+		}
+		c.channelMaxLines = make(map[string]int)
+		for _, entry := range splitTrim(strings.ToLower(value), ",") {
+			channel, limit := "", entry
+			if splitted := strings.Split(entry, ":"); len(splitted) == 2 {
+				channel, limit = splitted[0], splitted[1]
+			}
+			n, err := strconv.ParseUint(limit, 10, 16)
+			if err != nil || n == 0 {
+				log.Fatalf("%q is not valid QuoteMaxLines entry, expected positive integer or #channel:integer", entry)
+			}
+			if channel == "" {
+				c.quoteMaxLines = int(n)
+				continue
+			}
+			c.channelMaxLines[channel] = int(n)
+		}
+	}
+}
+
+func pasteListen(value string) option {
+	return func(c *config) {
+		if c.pasteListen != "" {
+			log.Fatalf("Repeated PasteListen assignment")
+		}
+		c.pasteListen = value
+	}
+}
+
+func pasteURL(value string) option {
+	return func(c *config) {
+		if c.pasteURL != "" {
+			log.Fatalf("Repeated PasteURL assignment")
+		}
+		c.pasteURL = value
+	}
+}
+
+func qotd(value string) option {
+	return func(c *config) {
+		if len(c.qotd) != 0 {
+			log.Fatalf("Repeated QOTD assignment")
+		}
+		for _, entry := range splitTrim(value, ",") {
+			splitted := strings.Split(entry, "@")
+			if len(splitted) != 2 {
+				log.Fatalf("%q is not valid QOTD entry, expected #channel@15:04", entry)
+			}
+			at, err := parseClock(splitted[1])
+			if err != nil {
+				log.Fatalf("%q is not valid QOTD time: %q", splitted[1], err)
+			}
+			c.qotd = append(c.qotd, qotdSchedule{channel: strings.ToLower(splitted[0]), at: at})
+		}
+	}
+}
+
+func qotdRating(value string) option {
+	return func(c *config) {
+		if c.qotdRating != 0 {
+			log.Fatalf("Repeated QOTDRating assignment")
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			log.Fatalf("%q is not valid integer for QOTDRating", value)
+		}
+		c.qotdRating = int(n)
+	}
+}
+
+func qotdHistory(value string) option {
+	return func(c *config) {
+		if c.qotdHistory != 0 {
+			log.Fatalf("Repeated QOTDHistory assignment")
+		}
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			log.Fatalf("%q is not valid number of days for QOTDHistory", value)
+		}
+		c.qotdHistory = int(n)
+	}
+}
+
+// priceAliases parses comma separated list of alias or alias:symbol entries
+func priceAliases(value string) option {
+	return func(c *config) {
+		if c.priceAliases != nil {
+			log.Fatalf("Repeated PriceAliases assignment")
+		}
+		c.priceAliases = make(map[string]string)
+		for _, entry := range splitTrim(value, ",") {
+			if entry == "" {
+				continue
+			}
+			alias, symbol := entry, entry
+			if i := strings.Index(entry, ":"); i != -1 {
+				alias, symbol = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+			}
+			c.priceAliases[strings.ToLower(alias)] = strings.ToUpper(symbol)
+		}
+	}
+}
+
+func priceProvidersOpt(value string) option {
+	return func(c *config) {
+		if len(c.priceProviders) != 0 {
+			log.Fatalf("Repeated PriceProviders assignment")
+		}
+		for _, name := range splitTrim(strings.ToLower(value), ",") {
+			if _, ok := priceProviders[name]; !ok {
+				log.Fatalf("Unknown price provider %q", name)
+			}
+			c.priceProviders = append(c.priceProviders, name)
+		}
+	}
+}
+
+func priceWatch(value string) option {
+	return func(c *config) {
+		if len(c.priceWatch) != 0 {
+			log.Fatalf("Repeated PriceWatch assignment")
+		}
+		for _, pair := range splitTrim(strings.ToUpper(value), ",") {
+			if len(strings.Split(pair, "/")) != 2 {
+				log.Fatalf("%q is not valid PriceWatch pair, expected BTC/USD", pair)
+			}
+			c.priceWatch = append(c.priceWatch, pair)
+		}
+	}
+}
+
+func priceInterval(value string) option {
+	return func(c *config) {
+		if c.priceInterval != 0 {
+			log.Fatalf("Repeated PriceInterval assignment")
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n < 60 {
+			log.Fatalf("%q is not valid number of seconds >= 60 for PriceInterval", value)
+		}
+		c.priceInterval = time.Duration(n) * time.Second
+	}
+}
+
+func alertLimit(value string) option {
+	return func(c *config) {
+		if c.alertLimit != 0 {
+			log.Fatalf("Repeated AlertLimit assignment")
+		}
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil || n == 0 {
+			log.Fatalf("%q is not valid positive integer for AlertLimit", value)
+		}
+		c.alertLimit = int(n)
+	}
+}
+
+func weatherProvidersOpt(value string) option {
+	return func(c *config) {
+		if len(c.weatherProviders) != 0 {
+			log.Fatalf("Repeated WeatherProviders assignment")
+		}
+		for _, name := range splitTrim(strings.ToLower(value), ",") {
+			if _, ok := weatherProviders[name]; !ok {
+				log.Fatalf("Unknown weather provider %q", name)
+			}
+			c.weatherProviders = append(c.weatherProviders, name)
+		}
+	}
+}
+
+// weatherUnits parses comma separated list of #channel:units entries
+func weatherUnits(value string) option {
+	return func(c *config) {
+		if c.weatherUnits != nil {
+			log.Fatalf("Repeated WeatherUnits assignment")
+		}
+		c.weatherUnits = make(map[string]string)
+		for _, entry := range splitTrim(strings.ToLower(value), ",") {
+			splitted := strings.Split(entry, ":")
+			if len(splitted) != 2 || (splitted[1] != unitsMetric && splitted[1] != unitsImperial) {
+				log.Fatalf("%q is not valid WeatherUnits entry, expected #channel:metric or #channel:imperial", entry)
+			}
+			c.weatherUnits[splitted[0]] = splitted[1]
+		}
+	}
+}
+
+// titleAllow parses comma separated list of host names, addresses and
+// networks in CIDR notation
+func titleAllow(value string) option {
+	return func(c *config) {
+		if len(c.titleAllow) != 0 {
+			log.Fatalf("Repeated TitleAllow assignment")
+		}
+		for _, entry := range splitTrim(value, ",") {
+			if strings.Contains(entry, "/") {
+				if _, _, err := net.ParseCIDR(entry); err != nil {
+					log.Fatalf("%q is not valid network for TitleAllow: %q", entry, err)
+				}
+			}
+			c.titleAllow = append(c.titleAllow, entry)
+		}
+	}
+}
+
+// titlePreview parses comma separated list of #channel:style entries
+func titlePreview(value string) option {
+	return func(c *config) {
+		if c.titlePreview != nil {
+			log.Fatalf("Repeated TitlePreview assignment")
+		}
+		c.titlePreview = make(map[string]string)
+		for _, entry := range splitTrim(strings.ToLower(value), ",") {
+			splitted := strings.Split(entry, ":")
+			if len(splitted) != 2 || (splitted[1] != previewTitle && splitted[1] != previewRich) {
+				log.Fatalf("%q is not valid TitlePreview entry, expected #channel:title or #channel:rich", entry)
+			}
+			c.titlePreview[splitted[0]] = splitted[1]
+		}
+	}
+}
+
+// titleSitesOpt parses comma separated list of host:extractor entries
+func titleSitesOpt(value string) option {
+	return func(c *config) {
+		if c.titleSites != nil {
+			log.Fatalf("Repeated TitleSites assignment")
+		}
+		c.titleSites = make(map[string]string)
+		for _, entry := range splitTrim(strings.ToLower(value), ",") {
+			splitted := strings.Split(entry, ":")
+			if len(splitted) != 2 || splitted[0] == "" {
+				log.Fatalf("%q is not valid TitleSites entry, expected host:extractor", entry)
+			}
+			if _, ok := siteExtractors[splitted[1]]; !ok {
+				log.Fatalf("Unknown site extractor %q", splitted[1])
+			}
+			c.titleSites[splitted[0]] = splitted[1]
+		}
+	}
+}
+
+// feedSection parses [feed name] header and starts the new feed
+func feedSection(c *config, line string) *feedConfig {
+	if !strings.HasSuffix(line, "]") {
+		log.Fatalf("Failed to parse section: %q", line)
+	}
+	fields := strings.Fields(line[1 : len(line)-1])
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "feed" {
+		log.Fatalf("%q is not valid section, expected [feed name]", line)
+	}
+	name := strings.ToLower(fields[1])
+	for _, feed := range c.feeds {
+		if feed.name == name {
+			log.Fatalf("Repeated feed %q", name)
+		}
+	}
+	feed := &feedConfig{name: name}
+	c.feeds = append(c.feeds, feed)
+	return feed
+}
+
+func feedURL(value string) feedOption {
+	return func(f *feedConfig) {
+		if f.url != "" {
+			log.Fatalf("Repeated URL assignment in feed %q", f.name)
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			log.Fatalf("%q is not valid URL of feed %q", value, f.name)
+		}
+		f.url = value
+	}
+}
+
+func feedInterval(value string) feedOption {
+	return func(f *feedConfig) {
+		if f.interval != 0 {
+			log.Fatalf("Repeated Interval assignment in feed %q", f.name)
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n < 60 {
+			log.Fatalf("%q is not valid number of seconds >= 60 for Interval of feed %q", value, f.name)
+		}
+		f.interval = time.Duration(n) * time.Second
+	}
+}
+
+func feedChannels(value string) feedOption {
+	return func(f *feedConfig) {
+		if len(f.channels) != 0 {
+			log.Fatalf("Repeated Channels assignment in feed %q", f.name)
+		}
+		f.channels = splitTrim(strings.ToLower(value), ",")
+	}
+}
+
+func feedPrefix(value string) feedOption {
+	return func(f *feedConfig) {
+		if f.prefix != "" {
+			log.Fatalf("Repeated Prefix assignment in feed %q", f.name)
+		}
+		f.prefix = value
+	}
+}
+
+func feedType(value string) feedOption {
+	return func(f *feedConfig) {
+		if f.kind != "" {
+			log.Fatalf("Repeated Type assignment in feed %q", f.name)
+		}
+		value = strings.ToLower(value)
+		if !contains(feedTypes, value) {
+			log.Fatalf("%q is not valid Type of feed %q, expected one of %s", value, f.name, strings.Join(feedTypes, ","))
+		}
+		f.kind = value
+	}
+}
+
+func feedSummary(value string) feedOption {
+	return func(f *feedConfig) {
+		if f.summary != 0 {
+			log.Fatalf("Repeated Summary assignment in feed %q", f.name)
+		}
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			log.Fatalf("%q is not valid number of characters for Summary of feed %q", value, f.name)
+		}
+		f.summary = int(n)
+	}
+}
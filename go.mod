@@ -1,9 +1,10 @@
 module gitea.demsh.org/demsh/aptajm
 
-go 1.17
+go 1.18
 
 require (
 	gitea.demsh.org/demsh/ircfw v0.1.0
+	github.com/andybalholm/brotli v1.1.1
 	github.com/mattn/go-sqlite3 v1.14.19
 	golang.org/x/net v0.20.0
 	golang.org/x/sys v0.16.0
@@ -26,7 +26,20 @@ const (
 	RecursionLimit = 1000
 )
 
-var playerResponsePattern = regexp.MustCompile(`var ytInitialPlayerResponse\s=\s(\{.+?\});`)
+const (
+	// runes of og:description in rich previews
+	previewDescription = 150
+)
+
+var (
+	playerResponsePattern = regexp.MustCompile(`var ytInitialPlayerResponse\s=\s(\{.+?\});`)
+
+	// lowercase titles replaced by og:title or twitter:title
+	genericTitles = []string{
+		"twitch", "youtube", "instagram", "facebook", "x", "twitter",
+		"home", "index", "untitled", "welcome", "loading...",
+	}
+)
 
 /*
  * Kudos to https://siongui.github.io/
@@ -48,58 +61,43 @@ func defaultExtractor(n *html.Node) (string, bool) {
 	return "", false
 }
 
-func twitchExtractor(n *html.Node) (string, bool) {
-	if n == nil {
-		return "", false
-	}
-	for _, attr := range n.Attr {
-		if attr.Key == "content" {
-			return strings.TrimSpace(attr.Val), true
-		}
-	}
-	return "", false
-
+func isMetaElement(n *html.Node) bool {
+	return n.Type == html.ElementNode && n.Data == "meta"
 }
 
-func tgExtractor(n *html.Node) (string, bool) {
-	if n == nil {
-		return "", false
-	}
-	return n.FirstChild.Data, true
+// pageMeta holds OpenGraph and Twitter card fields of the page
+type pageMeta struct {
+	title, siteName, description string
 }
 
-func isTGElement(n *html.Node) bool {
-	if n.Type == html.ElementNode && n.Data == "div" {
+// readMeta takes the first non-empty value of each field,
+// og: properties win over twitter: and plain meta names
+func readMeta(tree *html.Node) (meta pageMeta) {
+	values := make(map[string]string)
+	for _, n := range collect(tree, 0, isMetaElement, nil) {
+		var key, content string
 		for _, attr := range n.Attr {
-			if attr.Key == "class" && attr.Val == "tgme_widget_message_text js-message_text" {
-				return true
+			switch attr.Key {
+			case "property", "name":
+				key = strings.ToLower(strings.TrimSpace(attr.Val))
+			case "content":
+				content = strings.Join(strings.Fields(attr.Val), " ")
 			}
 		}
+		if _, ok := values[key]; !ok && key != "" && content != "" {
+			values[key] = content
+		}
 	}
-	return false
-}
-
-func extractTGLastPost(data io.Reader) (quote string, err error) {
-	tree, err := html.Parse(data)
-	if err != nil {
-		return
-	}
-	quote, ok := revTraverse(tree, 0, isTGElement, tgExtractor)
-	if !ok {
-		return "", errors.New("failed to extract post")
-	}
+	meta.title = firstNonEmpty(values["og:title"], values["twitter:title"])
+	meta.siteName = values["og:site_name"]
+	meta.description = firstNonEmpty(values["og:description"], values["twitter:description"], values["description"])
 	return
 }
 
-func isTwitchElement(n *html.Node) bool {
-	if n.Type == html.ElementNode && n.Data == "meta" {
-		for _, attr := range n.Attr {
-			if attr.Key == "property" && attr.Val == "og:title" {
-				return true
-			}
-		}
-	}
-	return false
+// isGenericTitle tells titles naming the site rather than the page
+func isGenericTitle(title string, meta pageMeta) bool {
+	title = strings.ToLower(title)
+	return title == "" || title == strings.ToLower(meta.siteName) || contains(genericTitles, title)
 }
 
 func traverse(n *html.Node, depth uint, filter filterFunc, extractor extractFunc) (string, bool) {
@@ -120,6 +118,22 @@ func traverse(n *html.Node, depth uint, filter filterFunc, extractor extractFunc
 	return "", false
 }
 
+// collect returns all nodes matching filter in document order without
+// descending into matched ones
+func collect(n *html.Node, depth uint, filter filterFunc, found []*html.Node) []*html.Node {
+	depth++
+	if depth == RecursionLimit {
+		return found
+	}
+	if filter(n) {
+		return append(found, n)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		found = collect(child, depth, filter, found)
+	}
+	return found
+}
+
 func revTraverse(n *html.Node, depth uint, filter filterFunc, extractor extractFunc) (string, bool) {
 	depth++
 	if depth == RecursionLimit {
@@ -138,32 +152,46 @@ func revTraverse(n *html.Node, depth uint, filter filterFunc, extractor extractF
 	return "", false
 }
 
-func extractTitle(data io.Reader, utf8 bool) (title string, err error) {
+func extractTitle(data io.Reader, utf8 bool, rich bool) (title string, err error) {
+	tree, err := parsePage(data, utf8)
+	if err != nil {
+		return
+	}
+	return pageTitle(tree, rich)
+}
+
+func parsePage(data io.Reader, utf8 bool) (tree *html.Node, err error) {
 	if !utf8 {
 		data, err = htmlToUTF8(data)
 		if err != nil {
 			return
 		}
 	}
-	tree, err := html.Parse(data)
-	if err != nil {
-		return
+	return html.Parse(data)
+}
+
+// pageTitle prefers OpenGraph or Twitter card title over generic <title>,
+// rich previews append the site name and the description
+func pageTitle(tree *html.Node, rich bool) (string, error) {
+	title, _ := traverse(tree, 0, isTitleElement, defaultExtractor)
+	meta := readMeta(tree)
+	if isGenericTitle(title, meta) && meta.title != "" {
+		title = meta.title
 	}
-	title, ok := traverse(tree, 0, isTitleElement, defaultExtractor)
-	if !ok {
+	if title == "" {
 		return "", errors.New("failed to find title")
 	}
-	if strings.HasSuffix(title, " YouTube") {
-		title = extractYoutube(tree, title)
+	if !rich {
+		return title, nil
 	}
-	switch title {
-	case "Twitch":
-		title, ok = traverse(tree, 0, isTwitchElement, twitchExtractor)
-		if !ok {
-			title = "Twitch"
-		}
+	parts := []string{title}
+	if meta.siteName != "" && !strings.Contains(strings.ToLower(title), strings.ToLower(meta.siteName)) {
+		parts = append(parts, meta.siteName)
 	}
-	return
+	if meta.description != "" && meta.description != title {
+		parts = append(parts, trimRunes(meta.description, previewDescription))
+	}
+	return strings.Join(parts, " \x0310|\x03 "), nil
 }
 
 func isYoutubeElement(n *html.Node) bool {
@@ -236,7 +264,7 @@ func extractYoutube(n *html.Node, fallback string) string {
 }
 
 func htmlToUTF8(data io.Reader) (result io.Reader, err error) {
-	b, err := ioutil.ReadAll(data)
+	b, err := ioutil.ReadAll(io.LimitReader(data, maxBodySize))
 	if err != nil {
 		return nil, err
 	}
@@ -264,16 +292,21 @@ func determineEncoding(data io.Reader) (e encoding.Encoding, name string, certai
 	return
 }
 
+// checkContentType matches header against contentType,
+// which may list several comma separated alternatives
 func checkContentType(header string, contentType string) (ok bool, utf8 bool) {
 	header = strings.TrimSpace(header)
 	values := strings.Split(header, ";")
 	for _, value := range values {
 		value = strings.TrimSpace(strings.ToLower(value))
-		switch value {
-		case contentType:
-			ok = true
-		case "charset=utf-8":
+		if value == "charset=utf-8" {
 			utf8 = true
+			continue
+		}
+		for _, expected := range strings.Split(contentType, ",") {
+			if value == expected {
+				ok = true
+			}
 		}
 	}
 	return
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	maxRedirects = 5
+	// titles are always within the first few hundred KB, feeds and API
+	// responses are smaller than that too
+	maxBodySize = 1 << 20
+	// concurrent requests to a single host
+	maxPerHost = 4
+)
+
+var (
+	errTooManyRedirects = fmt.Errorf("stopped after %d redirects", maxRedirects)
+
+	sharedClient = newHTTPClient(newDialer().DialContext)
+)
+
+// httpClient is used for all outbound requests. Connections are reused
+// between requests while cookies are kept for a single request only.
+type httpClient struct {
+	transport *http.Transport
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots limits concurrent requests to a host, it is dropped once
+// nobody uses it
+type hostSlots struct {
+	sem   chan struct{}
+	users int
+}
+
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func newDialer() *net.Dialer {
+	return &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 30 * time.Second}
+}
+
+func newHTTPClient(dial dialFunc) *httpClient {
+	return &httpClient{
+		transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dial,
+			ForceAttemptHTTP2:     true,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   maxPerHost,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			ExpectContinueTimeout: time.Second,
+			// encodings are handled in do, so br is supported as well
+			DisableCompression: true,
+		},
+		hosts: make(map[string]*hostSlots),
+	}
+}
+
+// newGuardedClient connects only to public addresses and to the allowlisted
+// ones. Proxies are not used since the guard would check the proxy address.
+func newGuardedClient(allow []string) (*httpClient, error) {
+	guard, err := newAddrGuard(newDialer(), allow)
+	if err != nil {
+		return nil, err
+	}
+	c := newHTTPClient(guard.dialContext)
+	c.transport.Proxy = nil
+	return c, nil
+}
+
+// do sends the request waiting for a free slot of its host. The response
+// body is decoded and capped at maxBodySize, closing it frees the slot.
+func (c *httpClient) do(request *http.Request) (*http.Response, error) {
+	host := request.URL.Hostname()
+	if err := c.acquire(request.Context(), host); err != nil {
+		return nil, err
+	}
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		c.release(host)
+		return nil, err
+	}
+	client := http.Client{
+		Transport: c.transport,
+		Jar:       jar,
+		CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errTooManyRedirects
+			}
+			return nil
+		},
+	}
+	request.Header.Set("Accept-Encoding", "gzip, br")
+	response, err := client.Do(request)
+	if err != nil {
+		c.release(host)
+		return nil, err
+	}
+	body, err := decodeBody(response)
+	if err != nil {
+		response.Body.Close()
+		c.release(host)
+		return nil, err
+	}
+	response.Body = &limitedBody{
+		Reader: io.LimitReader(body, maxBodySize),
+		closer: response.Body,
+		done:   func() { c.release(host) },
+	}
+	return response, nil
+}
+
+func (c *httpClient) acquire(ctx context.Context, host string) error {
+	c.mu.Lock()
+	slots, ok := c.hosts[host]
+	if !ok {
+		slots = &hostSlots{sem: make(chan struct{}, maxPerHost)}
+		c.hosts[host] = slots
+	}
+	slots.users++
+	c.mu.Unlock()
+	select {
+	case slots.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		c.drop(host, slots)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+func (c *httpClient) release(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	slots := c.hosts[host]
+	<-slots.sem
+	c.drop(host, slots)
+}
+
+// drop must be called with mu held
+func (c *httpClient) drop(host string, slots *hostSlots) {
+	slots.users--
+	if slots.users == 0 {
+		delete(c.hosts, host)
+	}
+}
+
+// decodeBody undoes Content-Encoding of the response
+func decodeBody(response *http.Response) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(response.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return response.Body, nil
+	case "gzip", "x-gzip":
+		body, err := gzip.NewReader(response.Body)
+		if err != nil {
+			return nil, err
+		}
+		response.Header.Del("Content-Encoding")
+		response.Header.Del("Content-Length")
+		response.ContentLength = -1
+		return body, nil
+	case "br":
+		response.Header.Del("Content-Encoding")
+		response.Header.Del("Content-Length")
+		response.ContentLength = -1
+		return brotli.NewReader(response.Body), nil
+	}
+	return nil, errors.New("unsupported Content-Encoding " + encoding)
+}
+
+// limitedBody closes the underlying body and runs done exactly once
+type limitedBody struct {
+	io.Reader
+	closer io.Closer
+	once   sync.Once
+	done   func()
+}
+
+func (b *limitedBody) Close() error {
+	err := b.closer.Close()
+	b.once.Do(b.done)
+	return err
+}
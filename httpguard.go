@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+var (
+	// special purpose ranges not covered by net.IP methods
+	reservedNets = mustParseCIDRs(
+		"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "192.0.2.0/24",
+		"198.18.0.0/15", "198.51.100.0/24", "203.0.113.0/24", "240.0.0.0/4",
+		// NAT64, 6to4 and Teredo embed IPv4 addresses
+		"64:ff9b::/96", "64:ff9b:1::/48", "2002::/16", "2001::/32",
+		"100::/64", "2001:db8::/32",
+	)
+)
+
+// addrGuard dials only public addresses, so pasted links can't reach the
+// bot's own network. Hosts and networks from the allowlist are not checked.
+type addrGuard struct {
+	dialer *net.Dialer
+	// lowercase host names
+	hosts []string
+	nets  []*net.IPNet
+}
+
+// newAddrGuard takes allowlist entries which are host names, addresses
+// or networks in CIDR notation
+func newAddrGuard(dialer *net.Dialer, allow []string) (*addrGuard, error) {
+	g := addrGuard{dialer: dialer}
+	for _, entry := range allow {
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			g.nets = append(g.nets, network)
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			g.nets = append(g.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+		g.hosts = append(g.hosts, strings.ToLower(entry))
+	}
+	return &g, nil
+}
+
+// dialContext resolves the host itself and connects to the checked address,
+// so DNS answers can't change between the check and the connection. It is
+// used for every connection, redirects included.
+func (g *addrGuard) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if contains(g.hosts, strings.ToLower(host)) {
+		return g.dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	// hosts resolving to a mix of public and internal addresses are refused
+	for _, addr := range addrs {
+		if !g.allowed(addr.IP) {
+			return nil, fmt.Errorf("%s resolves to non-public address %s", host, addr.IP)
+		}
+	}
+	err = fmt.Errorf("no addresses for %s", host)
+	for _, addr := range addrs {
+		var conn net.Conn
+		conn, err = g.dialer.DialContext(ctx, network, net.JoinHostPort(addr.IP.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+func (g *addrGuard) allowed(ip net.IP) bool {
+	for _, network := range g.nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return isPublic(ip)
+}
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, network := range reservedNets {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDRs(values ...string) (nets []*net.IPNet) {
+	for _, value := range values {
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, network)
+	}
+	return nets
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestIsPublic(t *testing.T) {
+	for _, tt := range []struct {
+		ip     string
+		public bool
+	}{
+		{"127.0.0.1", false},
+		{"127.8.8.8", false},
+		{"::1", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"172.31.255.255", false},
+		{"192.168.1.1", false},
+		{"fd00::1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"64:ff9b::7f00:1", false},
+		{"2002:7f00:1::", false},
+		{"224.0.0.1", false},
+		{"8.8.8.8", true},
+		{"172.32.0.1", true},
+		{"100.128.0.1", true},
+		{"::ffff:8.8.8.8", true},
+		{"2001:4860:4860::8888", true},
+	} {
+		if got := isPublic(net.ParseIP(tt.ip)); got != tt.public {
+			t.Errorf("isPublic(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestAddrGuard(t *testing.T) {
+	guard, err := newAddrGuard(newDialer(), []string{"10.1.0.0/16", "192.168.5.5", "fd00:1::/32", "Wiki.Example.LAN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guard.hosts) != 1 || guard.hosts[0] != "wiki.example.lan" {
+		t.Errorf("allowed hosts are %q, want lowercase wiki.example.lan", guard.hosts)
+	}
+	for _, tt := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"10.2.0.1", false},
+		{"192.168.5.5", true},
+		{"192.168.5.6", false},
+		{"fd00:1::5", true},
+		{"fd00:2::5", false},
+		{"127.0.0.1", false},
+		{"8.8.8.8", true},
+	} {
+		if got := guard.allowed(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("allowed(%s) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+	if _, err = newAddrGuard(newDialer(), []string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid network is accepted")
+	}
+}
+
+func TestGuardedRedirect(t *testing.T) {
+	var reached bool
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+		w.Header().Set("Content-Type", "text/html")
+	}))
+	defer internal.Close()
+	redirector := httptest.NewServer(http.RedirectHandler(internal.URL, http.StatusFound))
+	defer redirector.Close()
+	// the redirector is reached by the allowed host name, the redirect
+	// target by the loopback address
+	u, err := url.Parse(redirector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := "http://localhost:" + u.Port()
+
+	client, err := newGuardedClient([]string{"localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _, err := client.get(context.Background(), start, "text/html", "test")
+	if err == nil {
+		body.Close()
+		t.Fatal("redirect to 127.0.0.1 is followed")
+	}
+	if !strings.Contains(err.Error(), "non-public address 127.0.0.1") {
+		t.Errorf("redirect failed with %q, want refused address", err)
+	}
+	if reached {
+		t.Error("internal server is reached")
+	}
+
+	client, err = newGuardedClient([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _, err = client.get(context.Background(), start, "text/html", "test")
+	if err != nil {
+		t.Fatalf("allowed redirect failed: %v", err)
+	}
+	body.Close()
+	if !reached {
+		t.Error("allowed internal server is not reached")
+	}
+}
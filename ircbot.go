@@ -17,22 +17,81 @@ type dbStmt uint8
 type botCmd string
 
 const (
-	cmdBash    botCmd = "!bash"
-	cmdBtc     botCmd = "!btc"
-	cmdEth     botCmd = "!eth"
-	cmdXmr     botCmd = "!xmr"
-	cmdWeather botCmd = "!п"
-	cmdStatus  botCmd = "!status"
-	cmdQuit    botCmd = "!quit"
+	cmdBash     botCmd = "!bash"
+	cmdPrice    botCmd = "!price"
+	cmdAlert    botCmd = "!alert"
+	cmdConv     botCmd = "!conv"
+	cmdCity     botCmd = "!city"
+	cmdSet      botCmd = "!set"
+	cmdNews     botCmd = "!news"
+	cmdWeather  botCmd = "!п"
+	cmdForecast botCmd = "!forecast"
+	cmdStatus   botCmd = "!status"
+	cmdQuit     botCmd = "!quit"
 )
 
 const (
 	unknown dbStmt = iota
 	fetchQuote
+	fetchQuoteState
 	fetchRandomQuote
 	fetchRandomRating
+	searchQuote
+	searchQuoteIds
+	countQuoteMatches
+	fetchRandomQOTD
+	fetchQOTD
+	insertQOTD
+	quoteStats
+	quoteTopRated
+	quoteRatingBuckets
+	fetchQuoteText
+	deleteQuote
+	restoreQuote
+	editQuote
+	insertQuoteAudit
+	insertPriceSample
+	prunePriceHistory
+	insertAlert
+	countAlerts
+	listAlerts
+	deleteAlert
+	activeAlerts
+	firedAlert
+	fetchPrefs
+	upsertPref
+	deletePref
 	fetchCity
+	listCities
+	insertCity
+	deleteCity
 	ignoredDomain
+	hasSeenNews
+	seenNews
+	markNewsSeen
+	pruneSeenNews
+	hasFeed
+	upsertConfigFeed
+	resetConfigFeeds
+	insertFeed
+	deleteFeed
+	deleteFeedSubs
+	deleteFeedSeen
+	listFeeds
+	insertNewsSub
+	deleteNewsSub
+	listNewsSubs
+	channelNewsSubs
+	fetchNewsSub
+	feedNewsSubs
+	heldNewsSubs
+	updateNewsSub
+	flushedNewsSub
+	insertPendingNews
+	listPendingNews
+	deletePendingNews
+	flushPendingNews
+	deletePendingFeed
 )
 
 var (
@@ -47,20 +106,35 @@ type ircbot struct {
 	client   *ircfw.Client
 	handlers map[botCmd]handler
 	stmts    map[dbStmt]*sql.Stmt
-	logger   ircfw.Logger
-	config   config
-	mu       sync.Mutex
+	// quote search uses FTS5, otherwise searchQueries
+	fts bool
+	// in order of preference
+	priceProviders   []priceProvider
+	weatherProviders []weatherProvider
+	logger           ircfw.Logger
+	config           config
+	titleClient      *httpClient
+	titleSites       map[string]string
+	weatherCache     *ttlCache[string, weather]
+	forecastCache    *ttlCache[string, forecast]
+	geoCache         *ttlCache[string, []geoPlace]
+	currencyCache    *ttlCache[string, map[string]priceTicker]
+	historyCache     *ttlCache[string, []float64]
+	mu               sync.Mutex
 	// mutex protected fields
-	weatherCache  map[string]weather
-	currencyCache map[string]string
-	bashLimits    map[string]*time.Timer
-	channels      map[string]*ircfw.Channel
+	bashLimits map[string]*time.Timer
+	channels   map[string]*ircfw.Channel
+	// polled feeds by name
+	feeds map[string]*feedConfig
 }
 
 func newIRCBot(baseCtx context.Context, conf config, logger ircfw.Logger) (*ircbot, error) {
 	t, tombCtx := tomb.WithContext(baseCtx)
 	ircbot := ircbot{tomb: t, config: conf}
-	ircbot.initDB()
+	if err := ircbot.initDB(); err != nil {
+		t.Kill(err)
+		return nil, err
+	}
 
 	dialer := tls.Dialer{Config: &tls.Config{InsecureSkipVerify: true}}
 	ctx, cancel := context.WithTimeout(tombCtx, conf.timeout)
@@ -87,21 +161,56 @@ func newIRCBot(baseCtx context.Context, conf config, logger ircfw.Logger) (*ircb
 	)
 	ircbot.logger = logger
 	ircbot.client = client
-	ircbot.handlers = initHandlers()
+	if ircbot.titleClient, err = newGuardedClient(conf.titleAllow); err != nil {
+		t.Kill(err)
+		return nil, err
+	}
+	ircbot.titleSites = newTitleSites(conf.titleSites)
+	ircbot.handlers = initHandlers(conf)
+	for _, name := range conf.weatherProviders {
+		ircbot.weatherProviders = append(ircbot.weatherProviders, weatherProviders[name](conf.weatherToken, conf.userAgent))
+	}
+	for _, name := range conf.priceProviders {
+		ircbot.priceProviders = append(ircbot.priceProviders, priceProviders[name](conf.userAgent))
+	}
 
+	ircbot.weatherCache = newTTLCache[string, weather](tombCtx, conf.timeout, 10*time.Minute, 20*time.Minute)
+	ircbot.forecastCache = newTTLCache[string, forecast](tombCtx, conf.timeout, 30*time.Minute, 30*time.Minute)
+	ircbot.geoCache = newTTLCache[string, []geoPlace](tombCtx, conf.timeout, 24*time.Hour, time.Hour)
+	ircbot.currencyCache = newTTLCache[string, map[string]priceTicker](tombCtx, conf.timeout, 5*time.Minute, 10*time.Minute)
+	ircbot.historyCache = newTTLCache[string, []float64](tombCtx, conf.timeout, 30*time.Minute, 30*time.Minute)
 	ircbot.bashLimits = make(map[string]*time.Timer)
 	ircbot.channels = make(map[string]*ircfw.Channel)
+	ircbot.feeds = make(map[string]*feedConfig)
 
 	ircbot.tomb.Go(ircbot.finalizer)
-	ircbot.tomb.Go(ircbot.pruneWeatherCache)
-	ircbot.tomb.Go(ircbot.pruneCurrencyCache)
-	ircbot.tomb.Go(ircbot.pollNews)
+	ircbot.tomb.Go(ircbot.pruneCaches)
+	ctx, cancel = context.WithTimeout(tombCtx, conf.timeout)
+	feeds, err := ircbot.loadFeeds(ctx)
+	cancel()
+	if err != nil {
+		logger.Logf("Failed to load news feeds: %q", err)
+	}
+	for _, feed := range feeds {
+		ircbot.startFeed(feed)
+	}
+	ircbot.tomb.Go(ircbot.flushNews)
+	ircbot.tomb.Go(ircbot.samplePrices)
+	for _, schedule := range conf.qotd {
+		schedule := schedule
+		ircbot.tomb.Go(func() error {
+			return ircbot.postQOTD(schedule)
+		})
+	}
+	if conf.pasteListen != "" {
+		ircbot.tomb.Go(ircbot.servePaste)
+	}
 
 	for _, channel := range conf.channels {
 		ctx, cancel := context.WithTimeout(tombCtx, conf.timeout)
 		chHandle, err := ircbot.Join(ctx, channel)
 		cancel()
-		ircbot.channels[chHandle.Name()] = chHandle
+		ircbot.channels[strings.ToLower(chHandle.Name())] = chHandle
 		if err != nil {
 			logger.Logf("Error joining channel %q: %q", channel, err)
 		}
@@ -110,17 +219,29 @@ func newIRCBot(baseCtx context.Context, conf config, logger ircfw.Logger) (*ircb
 	return &ircbot, nil
 }
 
-func initHandlers() map[botCmd]handler {
-	return map[botCmd]handler{
-		cmdBash:    handleBash,
-		cmdWeather: handleWeather,
-		cmdBtc:     handleCurrencies,
-		cmdEth:     handleCurrencies,
-		cmdXmr:     handleCurrencies,
-		cmdStatus:  handleStatus,
-		cmdQuit:    handleQuit,
+func initHandlers(conf config) map[botCmd]handler {
+	handlers := map[botCmd]handler{
+		cmdBash:     handleBash,
+		cmdWeather:  handleWeather,
+		cmdForecast: handleForecast,
+		cmdPrice:    handlePrice,
+		cmdAlert:    handleAlert,
+		cmdConv:     handleConv,
+		cmdCity:     handleCity,
+		cmdSet:      handleSet,
+		cmdNews:     handleNews,
+		cmdStatus:   handleStatus,
+		cmdQuit:     handleQuit,
 	}
-
+	// price shortcuts like !btc must not shadow real commands
+	for alias := range conf.priceAliases {
+		cmd := botCmd("!" + alias)
+		if _, ok := handlers[cmd]; ok {
+			continue
+		}
+		handlers[cmd] = handleCurrencies
+	}
+	return handlers
 }
 
 func (b *ircbot) Join(ctx context.Context, channel string) (*ircfw.Channel, error) {
@@ -134,6 +255,14 @@ func (b *ircbot) Join(ctx context.Context, channel string) (*ircfw.Channel, erro
 	return ch, nil
 }
 
+// joined returns the handle of a joined channel, names are case-insensitive
+func (b *ircbot) joined(name string) (*ircfw.Channel, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	channel, ok := b.channels[strings.ToLower(name)]
+	return channel, ok && channel != nil
+}
+
 func (b *ircbot) finalizer() error {
 	<-b.tomb.Dying()
 	b.db.Close()
@@ -141,6 +270,23 @@ func (b *ircbot) finalizer() error {
 	return tomb.ErrDying
 }
 
+func (b *ircbot) pruneCaches() error {
+	ticker := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-b.tomb.Dying():
+			ticker.Stop()
+			return tomb.ErrDying
+		case <-ticker.C:
+		}
+		b.weatherCache.Prune()
+		b.forecastCache.Prune()
+		b.geoCache.Prune()
+		b.currencyCache.Prune()
+		b.historyCache.Prune()
+	}
+}
+
 func (b *ircbot) Wait() error {
 	return b.client.Wait()
 }
@@ -162,12 +308,32 @@ func (b *ircbot) Debug(format string, params ...interface{}) {
 	b.logger.Debug(format, params...)
 }
 
-func (b *ircbot) isAdmin(prefix string) bool {
+// replyPrivate answers to the sender of msg by private message
+// even if msg was sent to a channel
+func (b *ircbot) replyPrivate(ctx context.Context, msg ircfw.Msg, lines []string) {
+	if msg.IsPrivate() {
+		msg.Reply(ctx, lines)
+		return
+	}
+	if err := b.client.Privmsg(ctx, msg.Nick(), lines); err != nil {
+		b.Logf("Failed to send private message to %q: %q", msg.Nick(), err)
+	}
+}
+
+// account returns ident@host part of the prefix
+func account(prefix string) string {
 	i := strings.Index(prefix, "!")
 	if i == -1 {
+		return ""
+	}
+	return prefix[i+1:]
+}
+
+func (b *ircbot) isAdmin(prefix string) bool {
+	prefix = account(prefix)
+	if prefix == "" {
 		return false
 	}
-	prefix = prefix[i+1:]
 	for _, admin := range b.config.admins {
 		if prefix == admin {
 			return true
@@ -176,6 +342,30 @@ func (b *ircbot) isAdmin(prefix string) bool {
 	return false
 }
 
+func (b *ircbot) isTrusted(prefix string) bool {
+	if b.isAdmin(prefix) {
+		return true
+	}
+	prefix = account(prefix)
+	if prefix == "" {
+		return false
+	}
+	return contains(b.config.trusted, prefix)
+}
+
+// isChannelOp tells if the sender is listed in ChannelOps of the channel
+// the message was sent to, admins manage every channel
+func (b *ircbot) isChannelOp(msg ircfw.Msg) bool {
+	if b.isAdmin(msg.Prefix()) {
+		return true
+	}
+	user := account(msg.Prefix())
+	if msg.IsPrivate() || user == "" {
+		return false
+	}
+	return contains(b.config.channelOps[strings.ToLower(msg.Channel().Name())], user)
+}
+
 func handleQuit(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 	if !bot.isAdmin(msg.Prefix()) {
 		return
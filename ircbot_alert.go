@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitea.demsh.org/demsh/ircfw"
+	"gopkg.in/tomb.v2"
+)
+
+const (
+	alertUsage            = "Usage: !alert <symbol> <>|<> <price> [currency] | !alert list | !alert del <id>"
+	priceHistoryRetention = 365 * 24 * time.Hour
+)
+
+type priceAlert struct {
+	id            int64
+	nick, channel string
+	symbol, fiat  string
+	above         bool
+	threshold     float64
+}
+
+func (a priceAlert) condition() string {
+	op := "<"
+	if a.above {
+		op = ">"
+	}
+	return fmt.Sprintf("%s/%s %s %s", a.symbol, a.fiat, op, formatPrice(a.threshold))
+}
+
+func (a priceAlert) triggered(price float64) bool {
+	if a.above {
+		return price > a.threshold
+	}
+	return price < a.threshold
+}
+
+func handleAlert(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	params := strings.Fields(msg.Text()[0])[1:]
+	owner := userKey(msg)
+	switch {
+	case len(params) == 0:
+		msg.Reply(ctx, []string{alertUsage})
+	case strings.ToLower(params[0]) == "list":
+		serveAlertList(ctx, bot, msg, owner)
+	case strings.ToLower(params[0]) == "del" && len(params) == 2:
+		serveAlertDelete(ctx, bot, msg, owner, params[1])
+	default:
+		serveAlertAdd(ctx, bot, msg, owner, params)
+	}
+}
+
+func serveAlertAdd(ctx context.Context, bot *ircbot, msg ircfw.Msg, owner string, params []string) {
+	var count int
+	if len(params) < 3 || len(params) > 4 || (params[1] != ">" && params[1] != "<") {
+		msg.Reply(ctx, []string{alertUsage})
+		return
+	}
+	alert := priceAlert{
+		nick:   msg.Nick(),
+		symbol: strings.ToUpper(params[0]),
+		fiat:   bot.defaultFiat(ctx, msg),
+		above:  params[1] == ">",
+	}
+	if len(params) == 4 {
+		alert.fiat = strings.ToUpper(params[3])
+	}
+	if !validSymbol(alert.symbol) || !validSymbol(alert.fiat) {
+		msg.Reply(ctx, []string{alertUsage})
+		return
+	}
+	threshold, err := strconv.ParseFloat(params[2], 64)
+	if err != nil || threshold <= 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("%q is not a valid price", params[2])})
+		return
+	}
+	alert.threshold = threshold
+	if !msg.IsPrivate() {
+		alert.channel = msg.Channel().Name()
+	}
+	err = bot.stmts[countAlerts].QueryRowContext(ctx, owner).Scan(&count)
+	if err != nil {
+		bot.Logf("Failed to count alerts of %q: %q", owner, err)
+		return
+	}
+	if count >= bot.config.alertLimit {
+		replyAlertLimit(ctx, msg, count)
+		return
+	}
+	tickers, err := bot.prices(ctx, alert.symbol, []string{alert.fiat}, false)
+	if errors.Is(err, errUnknownSymbol) {
+		msg.Reply(ctx, []string{fmt.Sprintf("Unknown symbol %s", alert.symbol)})
+		return
+	} else if err != nil {
+		bot.Logf("failed to get price for %q: %q", alert.symbol, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to get price for %s", alert.symbol)})
+		return
+	}
+	t, ok := tickers[alert.fiat]
+	if !ok {
+		msg.Reply(ctx, []string{fmt.Sprintf("Unknown currency %s", alert.fiat)})
+		return
+	}
+	if alert.triggered(t.Price) {
+		msg.Reply(ctx, []string{fmt.Sprintf("%s already holds, now %s", alert.condition(), formatPrice(t.Price))})
+		return
+	}
+	result, err := bot.stmts[insertAlert].ExecContext(ctx, owner, alert.nick, alert.channel,
+		alert.symbol, alert.fiat, alert.above, alert.threshold, time.Now().Unix(), bot.config.alertLimit)
+	if err != nil {
+		bot.Logf("Failed to save alert: %q", err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		// other alerts were added while the price was requested
+		replyAlertLimit(ctx, msg, bot.config.alertLimit)
+		return
+	}
+	id, _ := result.LastInsertId()
+	msg.Reply(ctx, []string{fmt.Sprintf("Alert #%d: %s, now %s", id, alert.condition(), formatPrice(t.Price))})
+}
+
+func replyAlertLimit(ctx context.Context, msg ircfw.Msg, count int) {
+	msg.Reply(ctx, []string{fmt.Sprintf("You already have %d alerts, delete some with !alert del <id>", count)})
+}
+
+func serveAlertList(ctx context.Context, bot *ircbot, msg ircfw.Msg, owner string) {
+	var list []string
+	rows, err := bot.stmts[listAlerts].QueryContext(ctx, owner)
+	if err != nil {
+		bot.Logf("Failed to list alerts of %q: %q", owner, err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alert priceAlert
+		if err = rows.Scan(&alert.id, &alert.symbol, &alert.fiat, &alert.above, &alert.threshold); err != nil {
+			bot.Logf("Failed to scan alert: %q", err)
+			return
+		}
+		list = append(list, fmt.Sprintf("#%d: %s", alert.id, alert.condition()))
+	}
+	if err = rows.Err(); err != nil {
+		bot.Logf("Failed to list alerts of %q: %q", owner, err)
+		return
+	}
+	if len(list) == 0 {
+		msg.Reply(ctx, []string{"You have no alerts"})
+		return
+	}
+	msg.Reply(ctx, []string{strings.Join(list, ", ")})
+}
+
+func serveAlertDelete(ctx context.Context, bot *ircbot, msg ircfw.Msg, owner string, param string) {
+	id, err := strconv.ParseInt(strings.TrimPrefix(param, "#"), 10, 64)
+	if err != nil {
+		msg.Reply(ctx, []string{alertUsage})
+		return
+	}
+	result, err := bot.stmts[deleteAlert].ExecContext(ctx, id, owner)
+	if err != nil {
+		bot.Logf("Failed to delete alert %d: %q", id, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("You have no alert #%d", id)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("Alert #%d deleted", id)})
+}
+
+func (b *ircbot) loadAlerts(ctx context.Context) (alerts []priceAlert, err error) {
+	rows, err := b.stmts[activeAlerts].QueryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alert priceAlert
+		err = rows.Scan(&alert.id, &alert.nick, &alert.channel, &alert.symbol, &alert.fiat, &alert.above, &alert.threshold)
+		if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// samplePrices records watched pairs into price history and fires alerts
+func (b *ircbot) samplePrices() error {
+	rootctx := b.tomb.Context(nil)
+	ticker := time.NewTicker(b.config.priceInterval)
+	for {
+		select {
+		case <-b.tomb.Dying():
+			ticker.Stop()
+			return tomb.ErrDying
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(rootctx, b.config.timeout)
+		alerts, err := b.loadAlerts(ctx)
+		cancel()
+		if err != nil {
+			b.Logf("Failed to load alerts: %q", err)
+		}
+		if len(alerts) == 0 && len(b.config.priceWatch) == 0 {
+			continue
+		}
+		pairs := make(map[string][]string)
+		watched := make(map[string]bool)
+		for _, pair := range b.config.priceWatch {
+			splitted := strings.Split(pair, "/")
+			pairs[splitted[0]] = append(pairs[splitted[0]], splitted[1])
+			watched[pair] = true
+		}
+		for _, alert := range alerts {
+			if !contains(pairs[alert.symbol], alert.fiat) {
+				pairs[alert.symbol] = append(pairs[alert.symbol], alert.fiat)
+			}
+		}
+		now := time.Now()
+		for symbol, fiats := range pairs {
+			ctx, cancel := context.WithTimeout(rootctx, b.config.timeout)
+			tickers, err := b.getPrice(ctx, symbol, fiats)
+			if err != nil {
+				cancel()
+				b.Logf("Failed to sample %q: %q", symbol, err)
+				continue
+			}
+			for fiat, t := range tickers {
+				if !watched[symbol+"/"+fiat] {
+					continue
+				}
+				_, err = b.stmts[insertPriceSample].ExecContext(ctx, symbol, fiat, now.Unix(), t.Price)
+				if err != nil {
+					b.Logf("Failed to record %s/%s: %q", symbol, fiat, err)
+				}
+			}
+			for _, alert := range alerts {
+				if t, ok := tickers[alert.fiat]; ok && alert.symbol == symbol && alert.triggered(t.Price) {
+					b.fireAlert(ctx, alert, t.Price)
+				}
+			}
+			cancel()
+		}
+		ctx, cancel = context.WithTimeout(rootctx, b.config.timeout)
+		_, err = b.stmts[prunePriceHistory].ExecContext(ctx, now.Add(-priceHistoryRetention).Unix())
+		cancel()
+		if err != nil {
+			b.Logf("Failed to prune price history: %q", err)
+		}
+	}
+}
+
+func (b *ircbot) fireAlert(ctx context.Context, alert priceAlert, price float64) {
+	if _, err := b.stmts[firedAlert].ExecContext(ctx, alert.id); err != nil {
+		b.Logf("Failed to remove fired alert %d: %q", alert.id, err)
+		return
+	}
+	text := fmt.Sprintf("%s: alert #%d %s, now %s", alert.nick, alert.id, alert.condition(), formatPrice(price))
+	if alert.channel != "" {
+		if channel, ok := b.joined(alert.channel); ok {
+			channel.Say(text)
+			return
+		}
+	}
+	if err := b.client.Privmsg(ctx, alert.nick, []string{text}); err != nil {
+		b.Logf("Failed to notify %q: %q", alert.nick, err)
+	}
+}
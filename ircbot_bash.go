@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -13,24 +14,26 @@ import (
 
 var (
 	quoteNotFound = fmt.Errorf("quote not found")
+	quoteDeleted  = fmt.Errorf("quote deleted")
 )
 
-func (b *ircbot) fetchQuote(ctx context.Context, qid int) (quote, error) {
+const (
+	searchListLimit = 50
+	bashCooldown    = time.Minute
+	// replies up to bashFreeLines long don't prolong the cooldown,
+	// every line above costs bashLinePenalty
+	bashFreeLines   = 4
+	bashLinePenalty = 5 * time.Second
+)
+
+func scanQuote(row *sql.Row) (quote, error) {
 	var (
 		id        int
 		timestamp int64
 		rating    int
 		text      string
-		err       error
 	)
-	switch {
-	case qid < 0:
-		return quote{}, quoteNotFound
-	case qid > 0:
-		err = b.stmts[fetchQuote].QueryRowContext(ctx, qid).Scan(&id, &timestamp, &rating, &text)
-	default:
-		err = b.stmts[fetchRandomQuote].QueryRowContext(ctx).Scan(&id, &timestamp, &rating, &text)
-	}
+	err := row.Scan(&id, &timestamp, &rating, &text)
 	if err == sql.ErrNoRows {
 		return quote{}, quoteNotFound
 	} else if err != nil {
@@ -39,21 +42,123 @@ func (b *ircbot) fetchQuote(ctx context.Context, qid int) (quote, error) {
 	return quote{Id: id, Date: time.Unix(timestamp, 0), Rating: rating, Text: strings.Split(text, "\n")}, nil
 }
 
-func (b *ircbot) fetchRandomRatingQuote(ctx context.Context, qrating int) (quote, error) {
-	var (
-		id        int
-		timestamp int64
-		rating    int
-		text      string
-		err       error
-	)
-	err = b.stmts[fetchRandomRating].QueryRowContext(ctx, qrating, qrating).Scan(&id, &timestamp, &rating, &text)
-	if err == sql.ErrNoRows {
+func (b *ircbot) fetchQuote(ctx context.Context, qid int) (quote, error) {
+	switch {
+	case qid < 0:
 		return quote{}, quoteNotFound
-	} else if err != nil {
-		return quote{}, err
+	case qid > 0:
+		q, err := scanQuote(b.stmts[fetchQuote].QueryRowContext(ctx, qid))
+		if err != quoteNotFound {
+			return q, err
+		}
+		if err = b.quoteState(ctx, qid); err != nil {
+			return quote{}, err
+		}
+		// restored concurrently
+		return quote{}, quoteNotFound
+	default:
+		return scanQuote(b.stmts[fetchRandomQuote].QueryRowContext(ctx))
 	}
-	return quote{Id: id, Date: time.Unix(timestamp, 0), Rating: rating, Text: strings.Split(text, "\n")}, nil
+}
+
+func (b *ircbot) fetchRandomRatingQuote(ctx context.Context, qrating int) (quote, error) {
+	return scanQuote(b.stmts[fetchRandomRating].QueryRowContext(ctx, qrating, qrating))
+}
+
+// quoteState returns quoteNotFound for quotes which never existed,
+// quoteDeleted for soft-deleted ones and nil otherwise
+func (b *ircbot) quoteState(ctx context.Context, qid int) error {
+	return scanQuoteState(b.stmts[fetchQuoteState].QueryRowContext(ctx, qid))
+}
+
+func scanQuoteState(row *sql.Row) error {
+	var deleted bool
+	err := row.Scan(&deleted)
+	switch {
+	case err == sql.ErrNoRows:
+		return quoteNotFound
+	case err != nil:
+		return err
+	case deleted:
+		return quoteDeleted
+	}
+	return nil
+}
+
+// searchQuote returns the best matching quote and the total number of matches
+func (b *ircbot) searchQuote(ctx context.Context, words []string) (quote, int, error) {
+	var total int
+	query := b.searchArg(words)
+	if query == "" {
+		return quote{}, 0, quoteNotFound
+	}
+	err := b.stmts[countQuoteMatches].QueryRowContext(ctx, query).Scan(&total)
+	if err != nil {
+		return quote{}, 0, err
+	}
+	if total == 0 {
+		return quote{}, 0, quoteNotFound
+	}
+	q, err := scanQuote(b.stmts[searchQuote].QueryRowContext(ctx, query))
+	return q, total, err
+}
+
+func (b *ircbot) searchQuoteIds(ctx context.Context, words []string, limit int) (ids []int, err error) {
+	query := b.searchArg(words)
+	if query == "" {
+		return nil, quoteNotFound
+	}
+	rows, err := b.stmts[searchQuoteIds].QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, quoteNotFound
+	}
+	return ids, nil
+}
+
+// searchArg makes the parameter of search statements, empty for no words
+func (b *ircbot) searchArg(words []string) string {
+	if b.fts {
+		return ftsQuery(words)
+	}
+	var terms []string
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			terms = append(terms, word)
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(terms)
+	return string(data)
+}
+
+// ftsQuery turns user input into an FTS5 query matching all words,
+// quoting each of them so FTS5 operators and punctuation are taken literally
+func ftsQuery(words []string) string {
+	var terms []string
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"`)
+	}
+	return strings.Join(terms, " ")
 }
 
 func extractId(lines []string) (int, error) {
@@ -76,13 +181,16 @@ func serveQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 		return
 	}
 	quote, err := bot.fetchQuote(ctx, id)
-	if err != nil {
+	switch {
+	case err == quoteDeleted:
+		msg.Reply(ctx, []string{fmt.Sprintf("Quote %d was deleted", id)})
+		return
+	case err != nil:
 		bot.Logf("Failed to get quote: %#v", err)
 		msg.Reply(ctx, []string{fmt.Sprintf("No quote with id %d", id)})
 		return
 	}
-	msg.Reply(ctx, quote.ircFormat())
-
+	sendQuote(ctx, bot, msg, quote)
 }
 
 func serveRandomQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
@@ -91,7 +199,7 @@ func serveRandomQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 		bot.Logf("Failed to get quote: %#v", err)
 		return
 	}
-	msg.Reply(ctx, quote.ircFormat())
+	sendQuote(ctx, bot, msg, quote)
 }
 
 func serveRatingQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
@@ -112,14 +220,128 @@ func serveRatingQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 		bot.Logf("Failed to get quote: %#v", err)
 		return
 	}
-	msg.Reply(ctx, quote.ircFormat())
+	sendQuote(ctx, bot, msg, quote)
+}
+
+func serveSearchQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg, words []string) {
+	quote, total, err := bot.searchQuote(ctx, words)
+	if err == quoteNotFound {
+		msg.Reply(ctx, []string{fmt.Sprintf("Nothing found for %q", strings.Join(words, " "))})
+		return
+	} else if err != nil {
+		bot.Logf("Failed to search quote: %#v", err)
+		return
+	}
+	if total > 1 {
+		sendQuote(ctx, bot, msg, quote, fmt.Sprintf("%d more matching, see !bash ?? %s", total-1, strings.Join(words, " ")))
+		return
+	}
+	sendQuote(ctx, bot, msg, quote)
+}
+
+// maxLines returns QuoteMaxLines of the channel
+func (c config) maxLines(channel string) int {
+	if n, ok := c.channelMaxLines[strings.ToLower(channel)]; ok {
+		return n
+	}
+	return c.quoteMaxLines
+}
+
+// sendQuote replies with the quote followed by footer lines. In channels quotes
+// longer than QuoteMaxLines are cut to a preview, the full text goes either
+// to the paste page or to the requester privately.
+func sendQuote(ctx context.Context, bot *ircbot, msg ircfw.Msg, q quote, footer ...string) {
+	lines := q.ircFormat()
+	if msg.IsPrivate() {
+		msg.Reply(ctx, append(lines, footer...))
+		cooldown(bot, msg, len(lines)+len(footer))
+		return
+	}
+	preview, rest := bot.config.previewQuote(msg.Channel().Name(), lines)
+	if rest == 0 {
+		msg.Reply(ctx, append(lines, footer...))
+		cooldown(bot, msg, len(lines)+len(footer))
+		return
+	}
+	if link := bot.pasteLink(q.Id); link != "" {
+		preview = append(preview, fmt.Sprintf("... %d more lines: %s", rest, link))
+		msg.Reply(ctx, append(preview, footer...))
+		cooldown(bot, msg, len(preview)+len(footer))
+		return
+	}
+	preview = append(preview, fmt.Sprintf("... %d more lines sent to %s privately", rest, msg.Nick()))
+	msg.Reply(ctx, append(preview, footer...))
+	cooldown(bot, msg, len(preview)+len(footer))
+	bot.replyPrivate(ctx, msg, append([]string{lines[0]}, lines[len(lines)-rest:]...))
+}
+
+// previewQuote cuts formatted quote lines to QuoteMaxLines of the channel,
+// rest is the number of lines left out
+func (c config) previewQuote(channel string, lines []string) (preview []string, rest int) {
+	maxLines := c.maxLines(channel)
+	if len(lines) <= maxLines {
+		return lines, 0
+	}
+	// header and at least one line of text
+	if maxLines < 2 {
+		maxLines = 2
+	}
+	return append([]string(nil), lines[:maxLines]...), len(lines) - maxLines
+}
+
+func serveSearchIds(ctx context.Context, bot *ircbot, msg ircfw.Msg, words []string) {
+	var reply string
+	ids, err := bot.searchQuoteIds(ctx, words, searchListLimit)
+	switch {
+	case err == quoteNotFound:
+		reply = fmt.Sprintf("Nothing found for %q", strings.Join(words, " "))
+	case err != nil:
+		bot.Logf("Failed to search quote ids: %#v", err)
+		return
+	default:
+		strIds := make([]string, 0, len(ids))
+		for _, id := range ids {
+			strIds = append(strIds, strconv.Itoa(id))
+		}
+		reply = fmt.Sprintf("Matching quotes: %s", strings.Join(strIds, ", "))
+		if len(ids) == searchListLimit {
+			reply += " (list truncated)"
+		}
+	}
+	bot.replyPrivate(ctx, msg, []string{reply})
 }
 
 func handleBash(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	firstline := msg.Text()[0]
+	params := strings.Fields(firstline)
+	if len(params) > 1 {
+		if admin, ok := bashAdminCmds[strings.ToLower(params[1])]; ok {
+			if bot.isAdmin(msg.Prefix()) {
+				admin(ctx, bot, msg, params[2:])
+			}
+			return
+		}
+	}
 	if !allow(bot, msg) {
 		return
 	}
-	firstline := msg.Text()[0]
+	// listing goes to the requester privately and doesn't flood the channel
+	if len(params) > 2 && params[1] == "??" {
+		serveSearchIds(ctx, bot, msg, params[2:])
+		return
+	}
+	if len(params) > 1 && strings.ToLower(params[1]) == "stats" {
+		serveQuoteStats(ctx, bot, msg)
+		return
+	}
+	if len(params) > 1 && strings.ToLower(params[1]) == "qotd" {
+		serveQOTD(ctx, bot, msg, params[2:])
+		return
+	}
+	if len(params) > 2 && params[1] == "?" {
+		serveSearchQuote(ctx, bot, msg, params[2:])
+		return
+	}
 	if params := splitTrim(firstline, " "); len(params) > 1 {
 		if strings.HasPrefix(params[1], "+") {
 			serveRatingQuote(ctx, bot, msg)
@@ -146,9 +368,30 @@ func allow(bot *ircbot, msg ircfw.Msg) bool {
 	bot.mu.Unlock()
 	select {
 	case <-limit.C:
-		limit.Reset(time.Minute)
+		limit.Reset(bashCooldown)
 		return true
 	default:
 	}
 	return false
 }
+
+// cooldown prolongs the channel limiter armed by allow according to the
+// number of lines sent
+func cooldown(bot *ircbot, msg ircfw.Msg, lines int) {
+	if msg.IsPrivate() || lines <= bashFreeLines {
+		return
+	}
+	bot.mu.Lock()
+	limit, ok := bot.bashLimits[msg.Channel().Name()]
+	bot.mu.Unlock()
+	if !ok {
+		return
+	}
+	if !limit.Stop() {
+		select {
+		case <-limit.C:
+		default:
+		}
+	}
+	limit.Reset(bashCooldown + time.Duration(lines-bashFreeLines)*bashLinePenalty)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gitea.demsh.org/demsh/ircfw"
+)
+
+const (
+	auditDelete  = "delete"
+	auditRestore = "restore"
+	auditEdit    = "edit"
+)
+
+var (
+	quoteNotDeleted = fmt.Errorf("quote not deleted")
+)
+
+type bashAdminCmd func(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string)
+
+var bashAdminCmds = map[string]bashAdminCmd{
+	"del":     handleQuoteDelete,
+	"restore": handleQuoteRestore,
+	"edit":    handleQuoteEdit,
+}
+
+func serveQuoteStats(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	var (
+		total, topId, topRating int
+		oldest, newest          int64
+		buckets                 [5]int
+	)
+	err := bot.stmts[quoteStats].QueryRowContext(ctx).Scan(&total, &oldest, &newest)
+	if err != nil {
+		bot.Logf("Failed to get quote stats: %#v", err)
+		return
+	}
+	if total == 0 {
+		msg.Reply(ctx, []string{"No quotes yet"})
+		return
+	}
+	err = bot.stmts[quoteTopRated].QueryRowContext(ctx).Scan(&topId, &topRating)
+	if err != nil {
+		bot.Logf("Failed to get top rated quote: %#v", err)
+		return
+	}
+	err = bot.stmts[quoteRatingBuckets].QueryRowContext(ctx).Scan(
+		&buckets[0], &buckets[1], &buckets[2], &buckets[3], &buckets[4])
+	if err != nil {
+		bot.Logf("Failed to get rating distribution: %#v", err)
+		return
+	}
+	msg.Reply(ctx, []string{
+		fmt.Sprintf("Quotes: \x0304%d\x03 :: oldest: \x0310%s\x03 :: newest: \x0310%s\x03 :: most voted: \x0304#%d\x03 (%d)",
+			total, time.Unix(oldest, 0).Format("2006-01-02"), time.Unix(newest, 0).Format("2006-01-02"),
+			topId, topRating),
+		fmt.Sprintf("Rating: <0: %d, 0-99: %d, 100-499: %d, 500-999: %d, 1000+: %d",
+			buckets[0], buckets[1], buckets[2], buckets[3], buckets[4]),
+	})
+}
+
+// changeQuote runs stmt against the quote and records the change in the audit
+// table within one transaction
+func (b *ircbot) changeQuote(ctx context.Context, action, actor string, qid int, newText sql.NullString, stmt dbStmt, args ...interface{}) error {
+	var oldText string
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = tx.StmtContext(ctx, b.stmts[fetchQuoteText]).QueryRowContext(ctx, qid).Scan(&oldText)
+	if err == sql.ErrNoRows {
+		return quoteNotFound
+	} else if err != nil {
+		return err
+	}
+	result, err := tx.StmtContext(ctx, b.stmts[stmt]).ExecContext(ctx, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		// quote is already in the requested state
+		err = scanQuoteState(tx.StmtContext(ctx, b.stmts[fetchQuoteState]).QueryRowContext(ctx, qid))
+		if err != nil {
+			return err
+		}
+		return quoteNotDeleted
+	}
+	_, err = tx.StmtContext(ctx, b.stmts[insertQuoteAudit]).ExecContext(ctx,
+		qid, action, actor, time.Now().Unix(), oldText, newText)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func parseQuoteId(ctx context.Context, msg ircfw.Msg, params []string, usage string) (int, bool) {
+	if len(params) < 1 {
+		msg.Reply(ctx, []string{usage})
+		return 0, false
+	}
+	id, err := strconv.Atoi(params[0])
+	if err != nil || id <= 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("%q is not a quote id", params[0])})
+		return 0, false
+	}
+	return id, true
+}
+
+func replyQuoteChange(ctx context.Context, bot *ircbot, msg ircfw.Msg, id int, done string, err error) {
+	switch {
+	case err == nil:
+		msg.Reply(ctx, []string{fmt.Sprintf("Quote %d %s", id, done)})
+	case err == quoteNotFound:
+		msg.Reply(ctx, []string{fmt.Sprintf("No quote with id %d", id)})
+	case err == quoteDeleted:
+		msg.Reply(ctx, []string{fmt.Sprintf("Quote %d is deleted", id)})
+	case err == quoteNotDeleted:
+		msg.Reply(ctx, []string{fmt.Sprintf("Quote %d is not deleted", id)})
+	default:
+		bot.Logf("Failed to change quote %d: %#v", id, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to change quote %d", id)})
+	}
+}
+
+func handleQuoteDelete(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	id, ok := parseQuoteId(ctx, msg, params, "Usage: !bash del <id>")
+	if !ok {
+		return
+	}
+	err := bot.changeQuote(ctx, auditDelete, msg.Prefix(), id, sql.NullString{}, deleteQuote, id)
+	replyQuoteChange(ctx, bot, msg, id, "deleted", err)
+}
+
+func handleQuoteRestore(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	id, ok := parseQuoteId(ctx, msg, params, "Usage: !bash restore <id>")
+	if !ok {
+		return
+	}
+	err := bot.changeQuote(ctx, auditRestore, msg.Prefix(), id, sql.NullString{}, restoreQuote, id)
+	replyQuoteChange(ctx, bot, msg, id, "restored", err)
+}
+
+// handleQuoteEdit replaces the quote text, literal \n in the text starts a new line
+func handleQuoteEdit(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	id, ok := parseQuoteId(ctx, msg, params, `Usage: !bash edit <id> <text, \n separates lines>`)
+	if !ok {
+		return
+	}
+	text := strings.TrimSpace(strings.Join(params[1:], " "))
+	if text == "" {
+		msg.Reply(ctx, []string{`Usage: !bash edit <id> <text, \n separates lines>`})
+		return
+	}
+	text = strings.ReplaceAll(text, `\n`, "\n")
+	newText := sql.NullString{String: text, Valid: true}
+	err := bot.changeQuote(ctx, auditEdit, msg.Prefix(), id, newText, editQuote, text, id)
+	replyQuoteChange(ctx, bot, msg, id, "updated", err)
+}
@@ -2,91 +2,276 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"strings"
 	"time"
 
 	"gitea.demsh.org/demsh/ircfw"
-	"gopkg.in/tomb.v2"
 )
 
 const (
-	btcURL = "https://min-api.cryptocompare.com/data/pricemulti?fsyms=%s&tsyms=USD"
+	// quote currencies per request
+	maxFiats     = 5
+	defaultFiat  = "USD"
+	priceUsage   = "Usage: !price [-v] <symbol> [currency...]"
+	historyHours = 24
+	maxSymbolLen = 10
+	// single provider shouldn't eat the whole command timeout
+	providerTimeout = 4 * time.Second
+)
+
+var (
+	sparkTicks = []rune("▁▂▃▄▅▆▇█")
 )
 
 func handleCurrencies(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
-	currency := strings.ToLower(msg.Text()[0][1:])
-	bot.mu.Lock()
-	price, ok := bot.currencyCache[currency]
-	bot.mu.Unlock()
-	if ok {
-		msg.Reply(ctx, []string{fmt.Sprintf("%s/USD: %s", strings.ToUpper(currency), price)})
+	params := strings.Fields(msg.Text()[0])
+	alias := strings.ToLower(strings.TrimPrefix(params[0], "!"))
+	symbol, ok := bot.config.priceAliases[alias]
+	if !ok {
 		return
 	}
-	price, err := getPrice(ctx, currency, bot.config.userAgent)
-	if err != nil {
-		bot.Logf("failed to get price for %q: %q", currency, err)
+	verbose, params := priceFlags(params[1:])
+	servePrice(ctx, bot, msg, verbose, symbol, params)
+}
+
+// priceFlags strips -v from params
+func priceFlags(params []string) (verbose bool, rest []string) {
+	for _, param := range params {
+		if param == "-v" {
+			verbose = true
+			continue
+		}
+		rest = append(rest, param)
+	}
+	return
+}
+
+func handlePrice(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	verbose, params := priceFlags(strings.Fields(msg.Text()[0])[1:])
+	if len(params) < 1 {
+		msg.Reply(ctx, []string{priceUsage})
 		return
 	}
-	bot.mu.Lock()
-	bot.currencyCache[currency] = price
-	bot.mu.Unlock()
-	msg.Reply(ctx, []string{fmt.Sprintf("%s/USD: %s", strings.ToUpper(currency), price)})
+	servePrice(ctx, bot, msg, verbose, params[0], params[1:])
 }
 
-func getPrice(ctx context.Context, currency string, userAgent string) (price string, err error) {
-	body, _, err := get(ctx, fmt.Sprintf(btcURL, currency), "application/json", userAgent)
-	if err != nil {
-		return "", err
+func servePrice(ctx context.Context, bot *ircbot, msg ircfw.Msg, verbose bool, symbol string, fiats []string) {
+	symbol = strings.ToUpper(symbol)
+	if !validSymbol(symbol) {
+		msg.Reply(ctx, []string{priceUsage})
+		return
+	}
+	if len(fiats) == 0 {
+		fiats = []string{bot.defaultFiat(ctx, msg)}
+	}
+	if len(fiats) > maxFiats {
+		fiats = fiats[:maxFiats]
+	}
+	for i, fiat := range fiats {
+		fiats[i] = strings.ToUpper(fiat)
+		if !validSymbol(fiats[i]) {
+			msg.Reply(ctx, []string{priceUsage})
+			return
+		}
 	}
-	defer body.Close()
+	tickers, err := bot.prices(ctx, symbol, fiats, verbose)
+	if errors.Is(err, errUnknownSymbol) {
+		msg.Reply(ctx, []string{fmt.Sprintf("Unknown symbol %s", symbol)})
+		return
+	} else if err != nil {
+		bot.Logf("failed to get price for %q: %q", symbol, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to get price for %s", symbol)})
+		return
+	}
+	if verbose {
+		replies := make([]string, 0, len(fiats))
+		for _, fiat := range fiats {
+			t, ok := tickers[fiat]
+			if !ok {
+				replies = append(replies, fmt.Sprintf("%s/%s: unknown", symbol, fiat))
+				continue
+			}
+			replies = append(replies, formatTickerVerbose(symbol, fiat, t))
+		}
+		msg.Reply(ctx, replies)
+		return
+	}
+	var providers []string
+	replies := make([]string, 0, len(fiats))
+	for _, fiat := range fiats {
+		t, ok := tickers[fiat]
+		if !ok {
+			replies = append(replies, fmt.Sprintf("%s/%s: unknown", symbol, fiat))
+			continue
+		}
+		replies = append(replies, formatTicker(symbol, fiat, t))
+		if !contains(providers, t.Provider) {
+			providers = append(providers, t.Provider)
+		}
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("%s (%s)", strings.Join(replies, ", "), strings.Join(providers, ", "))})
+}
 
-	return extractPrice(currency, body)
+func formatChange(change float64) string {
+	if change < 0 {
+		return fmt.Sprintf("\x0304%.2f%%\x03", change)
+	}
+	return fmt.Sprintf("\x0303+%.2f%%\x03", change)
 }
 
-func extractPrice(currency string, data io.Reader) (price string, err error) {
-	var raw interface{}
-	b, err := ioutil.ReadAll(data)
-	if err != nil {
-		return "", err
+func formatTicker(symbol, fiat string, t priceTicker) string {
+	if !t.HasChange {
+		return fmt.Sprintf("%s/%s: %s", symbol, fiat, formatPrice(t.Price))
+	}
+	return fmt.Sprintf("%s/%s: %s %s", symbol, fiat, formatPrice(t.Price), formatChange(t.Change))
+}
+
+func formatTickerVerbose(symbol, fiat string, t priceTicker) string {
+	parts := []string{formatTicker(symbol, fiat, t)}
+	if t.High > 0 && t.Low > 0 {
+		parts = append(parts, fmt.Sprintf("24h H/L: %s/%s", formatPrice(t.High), formatPrice(t.Low)))
+	}
+	if len(t.History) > 1 {
+		parts = append(parts, sparkline(t.History))
 	}
-	err = json.Unmarshal(b, &raw)
+	parts = append(parts, fmt.Sprintf("(%s)", t.Provider))
+	return strings.Join(parts, " \x0310|\x03 ")
+}
+
+// sparkline draws values scaled between their minimum and maximum
+func sparkline(values []float64) string {
+	if len(values) == 0 {
+		return ""
+	}
+	min, max := values[0], values[0]
+	for _, v := range values {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	var line strings.Builder
+	for _, v := range values {
+		i := len(sparkTicks) / 2
+		if max > min {
+			i = int((v - min) / (max - min) * float64(len(sparkTicks)-1))
+		}
+		line.WriteRune(sparkTicks[i])
+	}
+	return line.String()
+}
+
+// defaultFiat returns the quote currency preferred by the sender of msg
+func (b *ircbot) defaultFiat(ctx context.Context, msg ircfw.Msg) string {
+	if fiat, ok := b.prefs(ctx, msg)[prefCurrency]; ok {
+		return fiat
+	}
+	return defaultFiat
+}
+
+func validSymbol(symbol string) bool {
+	if len(symbol) == 0 || len(symbol) > maxSymbolLen {
+		return false
+	}
+	for _, r := range symbol {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+func formatPrice(price float64) string {
+	if price >= 1 || price == 0 {
+		return fmt.Sprintf("%.2f", price)
+	}
+	return fmt.Sprintf("%.4g", price)
+}
+
+// prices returns tickers of the symbol in requested currencies, using cached
+// values when possible. withHistory also fills hourly history if any
+// provider is able to give it.
+func (b *ircbot) prices(ctx context.Context, symbol string, fiats []string, withHistory bool) (map[string]priceTicker, error) {
+	key := symbol + "/" + strings.Join(fiats, ",")
+	cached, err := b.currencyCache.Get(ctx, key, func(ctx context.Context) (map[string]priceTicker, error) {
+		return b.getPrice(ctx, symbol, fiats)
+	})
 	if err != nil {
-		return "", err
-	}
-	array := raw.(map[string]interface{})
-	switch name := array[strings.ToUpper(currency)].(type) {
-	case map[string]interface{}:
-		switch maybePrice := name["USD"].(type) {
-		case float64:
-			price = fmt.Sprintf("%.2f", maybePrice)
-			return
-		default:
-			return "", fmt.Errorf("error parsing: %q", b)
+		return nil, err
+	}
+	// cached map is shared with other callers
+	result := make(map[string]priceTicker, len(cached))
+	for fiat, t := range cached {
+		result[fiat] = t
+	}
+	if !withHistory {
+		return result, nil
+	}
+	for fiat, t := range result {
+		fiat := fiat
+		history, err := b.historyCache.Get(ctx, symbol+"/"+fiat, func(ctx context.Context) ([]float64, error) {
+			return b.getHistory(ctx, symbol, fiat)
+		})
+		if err != nil {
+			b.Logf("failed to get history for %s/%s: %q", symbol, fiat, err)
+			continue
 		}
-	default:
-		return "", fmt.Errorf("error parsing: %q", b)
+		t.History = history
+		result[fiat] = t
 	}
+	return result, nil
 }
 
-func (b *ircbot) pruneCurrencyCache() error {
-	b.currencyCache = make(map[string]string)
-	ticker := time.NewTicker(10 * time.Minute)
-	for {
-		select {
-		case <-b.tomb.Dying():
-			ticker.Stop()
-			return tomb.ErrDying
-		case <-ticker.C:
+func (b *ircbot) getHistory(ctx context.Context, symbol, fiat string) (history []float64, err error) {
+	err = errors.New("no provider reports history")
+	for _, provider := range b.priceProviders {
+		hp, ok := provider.(historyProvider)
+		if !ok {
+			continue
+		}
+		pctx, cancel := context.WithTimeout(ctx, providerTimeout)
+		history, err = hp.History(pctx, symbol, fiat, historyHours)
+		cancel()
+		if err == nil && len(history) != 0 {
+			return history, nil
 		}
+	}
+	return nil, err
+}
 
-		b.mu.Lock()
-		if len(b.currencyCache) != 0 {
-			b.currencyCache = make(map[string]string)
+// getPrice asks providers in the configured order and returns the first
+// successful reply
+func (b *ircbot) getPrice(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error) {
+	var (
+		lastErr error
+		unknown int
+	)
+	for _, provider := range b.priceProviders {
+		pctx, cancel := context.WithTimeout(ctx, providerTimeout)
+		prices, err := provider.Prices(pctx, symbol, fiats)
+		cancel()
+		if err == nil && len(prices) > 0 {
+			return prices, nil
 		}
-		b.mu.Unlock()
+		if err == nil || errors.Is(err, errUnknownSymbol) {
+			unknown++
+			continue
+		}
+		b.Logf("%s failed to get price for %q: %q", provider.Name(), symbol, err)
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if unknown == len(b.priceProviders) {
+		return nil, errUnknownSymbol
+	}
+	if lastErr == nil {
+		lastErr = errUnknownSymbol
 	}
+	return nil, lastErr
 }
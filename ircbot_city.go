@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gitea.demsh.org/demsh/ircfw"
+)
+
+const (
+	cityUsage = "Usage: !city add <alias> <city>,<country> | !city del <alias> | !city list"
+	// candidates listed for ambiguous names
+	maxPlaces = 5
+)
+
+var (
+	errUnknownCity = errors.New("unknown city")
+)
+
+// ambiguousCity is returned when geocoding finds several places
+type ambiguousCity []geoPlace
+
+func (a ambiguousCity) Error() string {
+	return fmt.Sprintf("%d places match", len(a))
+}
+
+// lookupCity resolves alias to the location, aliases missing from the
+// database are geocoded with place names in lang
+func lookupCity(ctx context.Context, bot *ircbot, alias, lang string) (location, error) {
+	var (
+		city, country string
+	)
+	err := bot.stmts[fetchCity].QueryRowContext(ctx, alias).Scan(&city, &country)
+	if err == nil {
+		return location{City: city, Country: country}, nil
+	}
+	if err != sql.ErrNoRows {
+		return location{}, err
+	}
+	places, err := geocode(ctx, bot, alias, lang)
+	if err != nil {
+		return location{}, err
+	}
+	switch len(places) {
+	case 0:
+		return location{}, errUnknownCity
+	case 1:
+		return places[0].location(), nil
+	}
+	if len(places) > maxPlaces {
+		places = places[:maxPlaces]
+	}
+	return location{}, ambiguousCity(places)
+}
+
+// geocode returns distinct places matching name from the first provider
+// able to answer
+func geocode(ctx context.Context, bot *ircbot, name, lang string) ([]geoPlace, error) {
+	return bot.geoCache.Get(ctx, lang+"|"+name, func(ctx context.Context) (places []geoPlace, err error) {
+		for _, provider := range bot.weatherProviders {
+			places, err = provider.Geocode(ctx, name, lang)
+			if err == nil {
+				return
+			}
+			bot.Logf("%s failed to geocode %q: %q", provider.Name(), name, err)
+		}
+		return
+	})
+}
+
+func replyCityError(ctx context.Context, bot *ircbot, msg ircfw.Msg, alias string, err error) {
+	var ambiguous ambiguousCity
+	switch {
+	case errors.Is(err, errUnknownCity):
+		msg.Reply(ctx, []string{fmt.Sprintf("Unknown city %q", alias)})
+	case errors.As(err, &ambiguous):
+		names := make([]string, 0, len(ambiguous))
+		for _, place := range ambiguous {
+			names = append(names, place.String())
+		}
+		msg.Reply(ctx, []string{fmt.Sprintf("%q is ambiguous: %s. Try city,country",
+			alias, strings.Join(names, "; "))})
+	default:
+		bot.Logf("Weather for %q: %q", alias, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to get weather for %s, try again later", alias)})
+	}
+}
+
+func handleCity(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	params := strings.Fields(msg.Text()[0])[1:]
+	if len(params) < 1 {
+		msg.Reply(ctx, []string{cityUsage})
+		return
+	}
+	switch strings.ToLower(params[0]) {
+	case "list":
+		serveCityList(ctx, bot, msg)
+	case "add":
+		if !bot.isTrusted(msg.Prefix()) {
+			return
+		}
+		serveCityAdd(ctx, bot, msg, params[1:])
+	case "del":
+		if !bot.isTrusted(msg.Prefix()) {
+			return
+		}
+		serveCityDelete(ctx, bot, msg, params[1:])
+	default:
+		msg.Reply(ctx, []string{cityUsage})
+	}
+}
+
+func serveCityList(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	var aliases []string
+	rows, err := bot.stmts[listCities].QueryContext(ctx)
+	if err != nil {
+		bot.Logf("Failed to list cities: %q", err)
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var alias, city, country string
+		if err = rows.Scan(&alias, &city, &country); err != nil {
+			bot.Logf("Failed to scan city: %q", err)
+			return
+		}
+		aliases = append(aliases, fmt.Sprintf("%s=%s,%s", alias, city, country))
+	}
+	if err = rows.Err(); err != nil {
+		bot.Logf("Failed to list cities: %q", err)
+		return
+	}
+	if len(aliases) == 0 {
+		msg.Reply(ctx, []string{"No city aliases"})
+		return
+	}
+	// the list can be long, don't flood the channel
+	bot.replyPrivate(ctx, msg, []string{strings.Join(aliases, " ")})
+}
+
+func serveCityAdd(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	if len(params) < 2 {
+		msg.Reply(ctx, []string{cityUsage})
+		return
+	}
+	alias := strings.ToLower(params[0])
+	location := strings.Join(params[1:], " ")
+	i := strings.LastIndex(location, ",")
+	if i == -1 {
+		msg.Reply(ctx, []string{cityUsage})
+		return
+	}
+	city, country := strings.TrimSpace(location[:i]), strings.ToUpper(strings.TrimSpace(location[i+1:]))
+	if city == "" || len(country) != 2 {
+		msg.Reply(ctx, []string{cityUsage})
+		return
+	}
+	result, err := bot.stmts[insertCity].ExecContext(ctx, alias, city, country)
+	if err != nil {
+		bot.Logf("Failed to add city %q: %q", alias, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("Alias %q already exists", alias)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("Added %s = %s,%s", alias, city, country)})
+}
+
+func serveCityDelete(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	if len(params) != 1 {
+		msg.Reply(ctx, []string{cityUsage})
+		return
+	}
+	alias := strings.ToLower(params[0])
+	result, err := bot.stmts[deleteCity].ExecContext(ctx, alias)
+	if err != nil {
+		bot.Logf("Failed to delete city %q: %q", alias, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("No alias %q", alias)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("Deleted %s", alias)})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gitea.demsh.org/demsh/ircfw"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+const (
+	convUsage = "Usage: !conv <amount> <from> <to>"
+	// currency used to convert pairs providers don't quote directly
+	convBase = "USD"
+)
+
+func handleConv(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	params := strings.Fields(msg.Text()[0])[1:]
+	if len(params) != 3 {
+		msg.Reply(ctx, []string{convUsage})
+		return
+	}
+	amount, err := strconv.ParseFloat(strings.ReplaceAll(params[0], ",", "."), 64)
+	if err != nil || amount <= 0 || math.IsInf(amount, 0) {
+		msg.Reply(ctx, []string{fmt.Sprintf("%q is not a valid amount", params[0])})
+		return
+	}
+	from, to := strings.ToUpper(params[1]), strings.ToUpper(params[2])
+	if !validSymbol(from) || !validSymbol(to) {
+		msg.Reply(ctx, []string{convUsage})
+		return
+	}
+	rate, err := bot.rate(ctx, from, to)
+	if errors.Is(err, errUnknownSymbol) {
+		msg.Reply(ctx, []string{fmt.Sprintf("Don't know how to convert %s to %s", from, to)})
+		return
+	} else if err != nil {
+		bot.Logf("Failed to convert %s to %s: %q", from, to, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to convert %s to %s", from, to)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("%s %s = %s %s",
+		formatAmount(amount), from, formatAmount(amount*rate), to)})
+}
+
+// rate returns price of one from in to, directly if any provider quotes
+// the pair and through convBase otherwise
+func (b *ircbot) rate(ctx context.Context, from, to string) (float64, error) {
+	if from == to {
+		return 1, nil
+	}
+	tickers, err := b.prices(ctx, from, []string{to}, false)
+	if err == nil {
+		if t, ok := tickers[to]; ok && t.Price > 0 {
+			return t.Price, nil
+		}
+	} else if !errors.Is(err, errUnknownSymbol) {
+		return 0, err
+	}
+	fromBase, err := b.basePrice(ctx, from)
+	if err != nil {
+		return 0, err
+	}
+	toBase, err := b.basePrice(ctx, to)
+	if err != nil {
+		return 0, err
+	}
+	return fromBase / toBase, nil
+}
+
+func (b *ircbot) basePrice(ctx context.Context, symbol string) (float64, error) {
+	if symbol == convBase {
+		return 1, nil
+	}
+	tickers, err := b.prices(ctx, symbol, []string{convBase}, false)
+	if err != nil {
+		return 0, err
+	}
+	t, ok := tickers[convBase]
+	if !ok || t.Price <= 0 {
+		return 0, errUnknownSymbol
+	}
+	return t.Price, nil
+}
+
+// formatAmount groups digits like formatViews and keeps four significant
+// digits for small amounts and cents for large ones
+func formatAmount(amount float64) string {
+	decimals := 2
+	if amount != 0 && math.Abs(amount) < 1 {
+		decimals = 3 - int(math.Floor(math.Log10(math.Abs(amount))))
+		if decimals > 12 {
+			decimals = 12
+		}
+	}
+	p := message.NewPrinter(language.Ukrainian)
+	formatted := p.Sprintf("%.*f", decimals, amount)
+	if decimals > 2 {
+		// drop zeros left after rounding to significant digits
+		formatted = strings.TrimRight(formatted, "0")
+		formatted = strings.TrimRight(formatted, ",.")
+	}
+	return formatted
+}
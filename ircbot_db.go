@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"os"
 	"time"
 
@@ -17,14 +18,24 @@ func (b *ircbot) initDB() error {
 	}
 	ctx := b.tomb.Context(nil)
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	stmts, err := initStmts(ctx, db)
-	cancel()
+	defer cancel()
+	fts, err := hasFTS5(ctx, db)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if err = initSchema(ctx, db, fts); err != nil {
+		db.Close()
+		return err
+	}
+	stmts, err := initStmts(ctx, db, fts)
 	if err != nil {
 		db.Close()
 		return err
 	}
 	b.db = db
 	b.stmts = stmts
+	b.fts = fts
 	b.tomb.Go(b.backupLoop)
 	return nil
 }
@@ -64,37 +75,279 @@ func (b *ircbot) backupLoop() error {
 	}
 }
 
-func initStmts(ctx context.Context, db *sql.DB) (map[dbStmt]*sql.Stmt, error) {
-	prepQ, err := db.PrepareContext(ctx,
-		`SELECT id, date, rating, text FROM quotes WHERE id=?`)
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS quotes (
+		id INTEGER PRIMARY KEY,
+		date INTEGER NOT NULL,
+		rating INTEGER NOT NULL DEFAULT 0,
+		text TEXT NOT NULL,
+		deleted INTEGER NOT NULL DEFAULT 0)`,
+	`CREATE TABLE IF NOT EXISTS quotes_audit (
+		id INTEGER PRIMARY KEY,
+		quote_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		actor TEXT NOT NULL,
+		date INTEGER NOT NULL,
+		old_text TEXT,
+		new_text TEXT)`,
+	`CREATE TABLE IF NOT EXISTS cities (
+		alias TEXT PRIMARY KEY,
+		city TEXT NOT NULL,
+		country TEXT NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS ignored_domains (
+		domain TEXT PRIMARY KEY)`,
+	`CREATE TABLE IF NOT EXISTS qotd_history (
+		channel TEXT NOT NULL,
+		day TEXT NOT NULL,
+		quote_id INTEGER NOT NULL,
+		PRIMARY KEY (channel, day))`,
+	`CREATE TABLE IF NOT EXISTS price_history (
+		symbol TEXT NOT NULL,
+		fiat TEXT NOT NULL,
+		date INTEGER NOT NULL,
+		price REAL NOT NULL)`,
+	`CREATE INDEX IF NOT EXISTS price_history_pair ON price_history (symbol, fiat, date)`,
+	`CREATE TABLE IF NOT EXISTS price_alerts (
+		id INTEGER PRIMARY KEY,
+		owner TEXT NOT NULL,
+		nick TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		symbol TEXT NOT NULL,
+		fiat TEXT NOT NULL,
+		above INTEGER NOT NULL,
+		threshold REAL NOT NULL,
+		created INTEGER NOT NULL)`,
+	`CREATE TABLE IF NOT EXISTS user_prefs (
+		user TEXT NOT NULL,
+		key TEXT NOT NULL,
+		value TEXT NOT NULL,
+		PRIMARY KEY (user, key))`,
+	`CREATE TABLE IF NOT EXISTS news_seen (
+		feed TEXT NOT NULL,
+		item TEXT NOT NULL,
+		seen INTEGER NOT NULL,
+		PRIMARY KEY (feed, item))`,
+	`CREATE TABLE IF NOT EXISTS news_feeds (
+		name TEXT PRIMARY KEY,
+		url TEXT NOT NULL,
+		config INTEGER NOT NULL DEFAULT 0)`,
+	`CREATE TABLE IF NOT EXISTS news_subs (
+		feed TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		include TEXT NOT NULL DEFAULT '',
+		exclude TEXT NOT NULL DEFAULT '',
+		digest TEXT NOT NULL DEFAULT '',
+		quiet TEXT NOT NULL DEFAULT '',
+		flushed INTEGER NOT NULL DEFAULT 0,
+		PRIMARY KEY (feed, channel))`,
+	`CREATE TABLE IF NOT EXISTS news_pending (
+		id INTEGER PRIMARY KEY,
+		feed TEXT NOT NULL,
+		channel TEXT NOT NULL,
+		title TEXT NOT NULL,
+		added INTEGER NOT NULL)`,
+}
+
+// external content FTS5 index over quotes.text, kept in sync by triggers
+var ftsSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS quotes_fts USING fts5(
+		text, content='quotes', content_rowid='id', tokenize='unicode61')`,
+	`CREATE TRIGGER IF NOT EXISTS quotes_fts_insert AFTER INSERT ON quotes BEGIN
+		INSERT INTO quotes_fts(rowid, text) VALUES (new.id, new.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS quotes_fts_delete AFTER DELETE ON quotes BEGIN
+		INSERT INTO quotes_fts(quotes_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS quotes_fts_update AFTER UPDATE OF text ON quotes BEGIN
+		INSERT INTO quotes_fts(quotes_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		INSERT INTO quotes_fts(rowid, text) VALUES (new.id, new.text);
+	END`,
+}
+
+// ftsTriggers are dropped without FTS5, writes to quotes would fail otherwise
+var ftsTriggers = []string{"quotes_fts_insert", "quotes_fts_delete", "quotes_fts_update"}
+
+// hasFTS5 tells if go-sqlite3 was built with the sqlite_fts5 tag
+func hasFTS5(ctx context.Context, db *sql.DB) (bool, error) {
+	var used bool
+	err := db.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&used)
+	return used, err
+}
+
+// columns added to tables created by older versions
+var migrations = []struct {
+	table, column, definition string
+}{
+	{"quotes", "deleted", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+func hasColumn(ctx context.Context, db *sql.DB, table, column string) (bool, error) {
+	var found int
+	err := db.QueryRowContext(ctx,
+		`SELECT count(*) FROM pragma_table_info(?) WHERE name=?`, table, column).Scan(&found)
+	return found > 0, err
+}
+
+func initSchema(ctx context.Context, db *sql.DB, fts bool) error {
+	var synced int
+	err := db.QueryRowContext(ctx,
+		`SELECT count(*) FROM sqlite_master WHERE type='trigger' AND name=?`, ftsTriggers[0]).Scan(&synced)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	prepRandom, err := db.PrepareContext(ctx,
-		`SELECT id, date, rating, text FROM quotes LIMIT 1 OFFSET ABS(RANDOM()) % MAX((SELECT count(id) FROM quotes), 1)`)
-	if err != nil {
-		return nil, err
+	for _, query := range schema {
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			return err
+		}
 	}
-	prepRatingRandom, err := db.PrepareContext(ctx,
-		`SELECT id, date, rating, text FROM quotes WHERE rating>=? LIMIT 1 OFFSET ABS(RANDOM()) % MAX((SELECT count(id) FROM quotes WHERE rating>=?), 1)`)
-	if err != nil {
-		return nil, err
+	for _, m := range migrations {
+		ok, err := hasColumn(ctx, db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if ok {
+			continue
+		}
+		_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.table, m.column, m.definition))
+		if err != nil {
+			return err
+		}
 	}
-	prepCities, err := db.PrepareContext(ctx,
-		`SELECT city, country FROM cities WHERE alias=?`)
-	if err != nil {
-		return nil, err
+	if !fts {
+		for _, trigger := range ftsTriggers {
+			if _, err = db.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+trigger); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-	prepIgnDomain, err := db.PrepareContext(ctx,
-		`SELECT domain from ignored_domains where domain=?`)
-	if err != nil {
-		return nil, err
+	for _, query := range ftsSchema {
+		if _, err = db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	if synced == 0 {
+		// index quotes added before the FTS table or while it wasn't maintained
+		_, err = db.ExecContext(ctx, `INSERT INTO quotes_fts(quotes_fts) VALUES ('rebuild')`)
+	}
+	return err
+}
+
+var queries = map[dbStmt]string{
+	fetchQuote:      `SELECT id, date, rating, text FROM quotes WHERE id=? AND deleted=0`,
+	fetchQuoteState: `SELECT deleted FROM quotes WHERE id=?`,
+	fetchRandomQuote: `SELECT id, date, rating, text FROM quotes WHERE deleted=0
+		LIMIT 1 OFFSET ABS(RANDOM()) % MAX((SELECT count(id) FROM quotes WHERE deleted=0), 1)`,
+	fetchRandomRating: `SELECT id, date, rating, text FROM quotes WHERE rating>=? AND deleted=0
+		LIMIT 1 OFFSET ABS(RANDOM()) % MAX((SELECT count(id) FROM quotes WHERE rating>=? AND deleted=0), 1)`,
+	searchQuote: `SELECT q.id, q.date, q.rating, q.text FROM quotes_fts f JOIN quotes q ON q.id=f.rowid
+		WHERE quotes_fts MATCH ? AND q.deleted=0 ORDER BY f.rank LIMIT 1`,
+	searchQuoteIds: `SELECT q.id FROM quotes_fts f JOIN quotes q ON q.id=f.rowid
+		WHERE quotes_fts MATCH ? AND q.deleted=0 ORDER BY f.rank LIMIT ?`,
+	countQuoteMatches: `SELECT count(*) FROM quotes_fts f JOIN quotes q ON q.id=f.rowid
+		WHERE quotes_fts MATCH ? AND q.deleted=0`,
+	fetchRandomQOTD: `SELECT id, date, rating, text FROM quotes WHERE rating>=?1 AND deleted=0 AND id NOT IN
+			(SELECT quote_id FROM qotd_history WHERE channel=?2 AND day>=?3)
+		LIMIT 1 OFFSET ABS(RANDOM()) % MAX((SELECT count(id) FROM quotes WHERE rating>=?1 AND deleted=0 AND id NOT IN
+			(SELECT quote_id FROM qotd_history WHERE channel=?2 AND day>=?3)), 1)`,
+	fetchQOTD:     `SELECT quote_id FROM qotd_history WHERE channel=? AND day=?`,
+	insertQOTD:    `INSERT OR IGNORE INTO qotd_history (channel, day, quote_id) VALUES (?, ?, ?)`,
+	quoteStats:    `SELECT count(id), COALESCE(MIN(date), 0), COALESCE(MAX(date), 0) FROM quotes WHERE deleted=0`,
+	quoteTopRated: `SELECT id, rating FROM quotes WHERE deleted=0 ORDER BY rating DESC, id LIMIT 1`,
+	quoteRatingBuckets: `SELECT
+		COALESCE(SUM(rating<0), 0),
+		COALESCE(SUM(rating>=0 AND rating<100), 0),
+		COALESCE(SUM(rating>=100 AND rating<500), 0),
+		COALESCE(SUM(rating>=500 AND rating<1000), 0),
+		COALESCE(SUM(rating>=1000), 0)
+		FROM quotes WHERE deleted=0`,
+	fetchQuoteText: `SELECT text FROM quotes WHERE id=?`,
+	deleteQuote:    `UPDATE quotes SET deleted=1 WHERE id=? AND deleted=0`,
+	restoreQuote:   `UPDATE quotes SET deleted=0 WHERE id=? AND deleted=1`,
+	editQuote:      `UPDATE quotes SET text=? WHERE id=? AND deleted=0`,
+	insertQuoteAudit: `INSERT INTO quotes_audit (quote_id, action, actor, date, old_text, new_text)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+	insertPriceSample: `INSERT INTO price_history (symbol, fiat, date, price) VALUES (?, ?, ?, ?)`,
+	prunePriceHistory: `DELETE FROM price_history WHERE date<?`,
+	// inserts nothing when the owner has AlertLimit alerts already
+	insertAlert: `INSERT INTO price_alerts (owner, nick, channel, symbol, fiat, above, threshold, created)
+		SELECT ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8 WHERE (SELECT count(id) FROM price_alerts WHERE owner=?1) < ?9`,
+	countAlerts:  `SELECT count(id) FROM price_alerts WHERE owner=?`,
+	listAlerts:   `SELECT id, symbol, fiat, above, threshold FROM price_alerts WHERE owner=? ORDER BY id`,
+	deleteAlert:  `DELETE FROM price_alerts WHERE id=? AND owner=?`,
+	activeAlerts: `SELECT id, nick, channel, symbol, fiat, above, threshold FROM price_alerts`,
+	firedAlert:   `DELETE FROM price_alerts WHERE id=?`,
+	listCities:   `SELECT alias, city, country FROM cities ORDER BY alias`,
+	insertCity:   `INSERT OR IGNORE INTO cities (alias, city, country) VALUES (?, ?, ?)`,
+	deleteCity:   `DELETE FROM cities WHERE alias=?`,
+	fetchPrefs:   `SELECT key, value FROM user_prefs WHERE user=?`,
+	upsertPref: `INSERT INTO user_prefs (user, key, value) VALUES (?, ?, ?)
+		ON CONFLICT (user, key) DO UPDATE SET value=excluded.value`,
+	deletePref:    `DELETE FROM user_prefs WHERE user=? AND key=?`,
+	fetchCity:     `SELECT city, country FROM cities WHERE alias=?`,
+	ignoredDomain: `SELECT domain from ignored_domains where domain=?`,
+	hasSeenNews:   `SELECT EXISTS (SELECT 1 FROM news_seen WHERE feed=?)`,
+	seenNews:      `SELECT EXISTS (SELECT 1 FROM news_seen WHERE feed=? AND item=?)`,
+	markNewsSeen: `INSERT INTO news_seen (feed, item, seen) VALUES (?, ?, ?)
+		ON CONFLICT (feed, item) DO UPDATE SET seen=excluded.seen`,
+	pruneSeenNews: `DELETE FROM news_seen WHERE seen<?`,
+	hasFeed:       `SELECT EXISTS (SELECT 1 FROM news_feeds WHERE name=?)`,
+	upsertConfigFeed: `INSERT INTO news_feeds (name, url, config) VALUES (?, ?, 1)
+		ON CONFLICT (name) DO UPDATE SET url=excluded.url, config=1`,
+	resetConfigFeeds: `UPDATE news_feeds SET config=0`,
+	insertFeed:       `INSERT OR IGNORE INTO news_feeds (name, url) VALUES (?, ?)`,
+	deleteFeed:       `DELETE FROM news_feeds WHERE name=? AND config=0`,
+	deleteFeedSubs:   `DELETE FROM news_subs WHERE feed=?`,
+	deleteFeedSeen:   `DELETE FROM news_seen WHERE feed=?`,
+	listFeeds:        `SELECT name, url, config FROM news_feeds ORDER BY name`,
+	insertNewsSub:    `INSERT OR IGNORE INTO news_subs (feed, channel) VALUES (?, ?)`,
+	deleteNewsSub:    `DELETE FROM news_subs WHERE feed=? AND channel=?`,
+	listNewsSubs:     `SELECT channel FROM news_subs WHERE feed=? ORDER BY channel`,
+	channelNewsSubs:  `SELECT feed FROM news_subs WHERE channel=? ORDER BY feed`,
+	fetchNewsSub: `SELECT feed, channel, include, exclude, digest, quiet, flushed
+		FROM news_subs WHERE feed=? AND channel=?`,
+	feedNewsSubs: `SELECT feed, channel, include, exclude, digest, quiet, flushed
+		FROM news_subs WHERE feed=? ORDER BY channel`,
+	heldNewsSubs: `SELECT feed, channel, include, exclude, digest, quiet, flushed
+		FROM news_subs WHERE digest!='' OR quiet!=''`,
+	updateNewsSub: `UPDATE news_subs SET include=?, exclude=?, digest=?, quiet=?
+		WHERE feed=? AND channel=?`,
+	flushedNewsSub:    `UPDATE news_subs SET flushed=? WHERE feed=? AND channel=?`,
+	insertPendingNews: `INSERT INTO news_pending (feed, channel, title, added) VALUES (?, ?, ?, ?)`,
+	listPendingNews:   `SELECT id, title FROM news_pending WHERE feed=? AND channel=? ORDER BY id`,
+	deletePendingNews: `DELETE FROM news_pending WHERE feed=? AND channel=?`,
+	flushPendingNews:  `DELETE FROM news_pending WHERE feed=? AND channel=? AND id<=?`,
+	deletePendingFeed: `DELETE FROM news_pending WHERE feed=?`,
+}
+
+// searchQueries replace FTS5 queries without the sqlite_fts5 build tag.
+// Words come as JSON array and match as substrings, case-insensitive for
+// ASCII only, best rated quotes first.
+var searchQueries = map[dbStmt]string{
+	searchQuote: `SELECT id, date, rating, text FROM quotes q WHERE deleted=0 AND NOT EXISTS
+		(SELECT 1 FROM json_each(?) w WHERE instr(lower(q.text), lower(w.value))=0)
+		ORDER BY rating DESC, id LIMIT 1`,
+	searchQuoteIds: `SELECT id FROM quotes q WHERE deleted=0 AND NOT EXISTS
+		(SELECT 1 FROM json_each(?) w WHERE instr(lower(q.text), lower(w.value))=0)
+		ORDER BY rating DESC, id LIMIT ?`,
+	countQuoteMatches: `SELECT count(*) FROM quotes q WHERE deleted=0 AND NOT EXISTS
+		(SELECT 1 FROM json_each(?) w WHERE instr(lower(q.text), lower(w.value))=0)`,
+}
+
+func initStmts(ctx context.Context, db *sql.DB, fts bool) (map[dbStmt]*sql.Stmt, error) {
+	stmts := make(map[dbStmt]*sql.Stmt, len(queries))
+	for kind, query := range queries {
+		if substitute, ok := searchQueries[kind]; ok && !fts {
+			query = substitute
+		}
+		stmt, err := db.PrepareContext(ctx, query)
+		if err != nil {
+			for _, stmt := range stmts {
+				stmt.Close()
+			}
+			return nil, fmt.Errorf("failed to prepare %q: %w", query, err)
+		}
+		stmts[kind] = stmt
 	}
-	return map[dbStmt]*sql.Stmt{
-		fetchQuote:        prepQ,
-		fetchRandomQuote:  prepRandom,
-		fetchRandomRating: prepRatingRandom,
-		fetchCity:         prepCities,
-		ignoredDomain:     prepIgnDomain,
-	}, nil
+	return stmts, nil
 }
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gitea.demsh.org/demsh/ircfw"
+)
+
+const (
+	maxForecastDays     = 5
+	defaultForecastDays = 3
+	forecastUsage       = "Usage: !forecast [city] [days]"
+)
+
+func handleForecast(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	params := strings.Fields(msg.Text()[0])[1:]
+	prefs := bot.prefs(ctx, msg)
+	if _, err := strconv.Atoi(firstOr(params, "")); len(params) < 1 || err == nil {
+		// !forecast [days] uses the city saved with !set city
+		city, ok := prefs[prefCity]
+		if !ok {
+			msg.Reply(ctx, []string{forecastUsage})
+			return
+		}
+		params = append([]string{city}, params...)
+	}
+	days := defaultForecastDays
+	if len(params) > 1 {
+		n, err := strconv.Atoi(params[1])
+		if err != nil || n < 1 {
+			msg.Reply(ctx, []string{forecastUsage})
+			return
+		}
+		days = n
+	}
+	if days > maxForecastDays {
+		days = maxForecastDays
+	}
+	serveForecast(ctx, bot, msg, bot.weatherFormat(msg, prefs), strings.ToLower(params[0]), 0, days)
+}
+
+// serveForecast replies with count days starting from skip days after today
+func serveForecast(ctx context.Context, bot *ircbot, msg ircfw.Msg, format weatherFormat, alias string, skip, count int) {
+	f, err := getForecast(ctx, bot, alias, format.lang)
+	if err != nil {
+		replyCityError(ctx, bot, msg, alias, err)
+		return
+	}
+	days := f.Days
+	if len(days) <= skip {
+		return
+	}
+	days = days[skip:]
+	if len(days) > count {
+		days = days[:count]
+	}
+	lines := make([]string, 0, len(days)+1)
+	lines = append(lines, fmt.Sprintf("%s/%s:", f.Place, f.Country))
+	for _, day := range days {
+		lines = append(lines, day.format(format))
+	}
+	if len(lines) == 2 {
+		lines = []string{lines[0] + " " + lines[1]}
+	}
+	msg.Reply(ctx, lines)
+}
+
+func getForecast(ctx context.Context, bot *ircbot, alias, lang string) (result forecast, err error) {
+	loc, err := lookupCity(ctx, bot, alias, lang)
+	if err != nil {
+		return
+	}
+	for _, provider := range bot.weatherProviders {
+		provider := provider
+		// cache the longest forecast, shorter ones are cut from it
+		key := provider.Name() + "|" + lang + "|" + loc.key()
+		result, err = bot.forecastCache.Get(ctx, key, func(ctx context.Context) (forecast, error) {
+			return provider.Forecast(ctx, loc, maxForecastDays, lang)
+		})
+		if err == nil {
+			return
+		}
+		bot.Logf("%s failed to get forecast for %q: %q", provider.Name(), alias, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return
+}
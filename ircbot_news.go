@@ -2,63 +2,272 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"gopkg.in/tomb.v2"
 )
 
-func (b *ircbot) pollNews() error {
-	var next time.Duration
-	var oldnews string
-	now := time.Now()
-	min := now.Minute()
-	delta := 1 - min
-	if delta > 0 {
-		next = time.Minute * time.Duration(delta)
-	} else if delta == 0 {
-		next = time.Minute
-	} else {
-		next = time.Minute * time.Duration(60+delta)
+var (
+	errUnknownFeed  = errors.New("unknown feed")
+	errFeedInConfig = errors.New("feed is defined in the config")
+)
+
+const (
+	// let the bot join channels before the first poll
+	firstPollDelay = time.Minute
+	// the rest of a burst waits for the next poll not to flood channels
+	maxNewsPerPoll = 5
+	// seen items are forgotten after they leave the feed for that long
+	newsRetention = 90 * 24 * time.Hour
+)
+
+// loadFeeds registers feeds from the config and returns them together with
+// the ones added by !news add. Channels of config feeds are subscribed only
+// when the feed is new, later they are managed with !news sub and unsub.
+func (b *ircbot) loadFeeds(ctx context.Context) (feeds []*feedConfig, err error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err = tx.StmtContext(ctx, b.stmts[resetConfigFeeds]).ExecContext(ctx); err != nil {
+		return nil, err
+	}
+	for _, feed := range b.config.feeds {
+		var known bool
+		if err = tx.StmtContext(ctx, b.stmts[hasFeed]).QueryRowContext(ctx, feed.name).Scan(&known); err != nil {
+			return nil, err
+		}
+		if _, err = tx.StmtContext(ctx, b.stmts[upsertConfigFeed]).ExecContext(ctx, feed.name, feed.url); err != nil {
+			return nil, err
+		}
+		if known {
+			continue
+		}
+		for _, channel := range feed.channels {
+			if _, err = tx.StmtContext(ctx, b.stmts[insertNewsSub]).ExecContext(ctx, feed.name, channel); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	feeds = append(feeds, b.config.feeds...)
+	stored, err := b.storedFeeds(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, feed := range stored {
+		if !feed.config {
+			feeds = append(feeds, newFeed(feed.name, feed.url))
+		}
+	}
+	return feeds, nil
+}
+
+type storedFeed struct {
+	name, url string
+	// defined in the config file
+	config bool
+}
+
+func (b *ircbot) storedFeeds(ctx context.Context) (feeds []storedFeed, err error) {
+	rows, err := b.stmts[listFeeds].QueryContext(ctx)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
+	for rows.Next() {
+		var feed storedFeed
+		if err = rows.Scan(&feed.name, &feed.url, &feed.config); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+// newFeed configures feeds added with !news add
+func newFeed(name, url string) *feedConfig {
+	return &feedConfig{name: name, url: url, prefix: name, kind: feedAuto, interval: defaultFeedInterval}
+}
+
+func (b *ircbot) startFeed(feed *feedConfig) {
+	b.mu.Lock()
+	b.feeds[feed.name] = feed
+	b.mu.Unlock()
+	b.tomb.Go(func() error {
+		return b.pollFeed(feed)
+	})
+}
+
+func (b *ircbot) stopFeed(name string) {
+	b.mu.Lock()
+	delete(b.feeds, name)
+	b.mu.Unlock()
+}
+
+// activeFeed tells whether the poller of feed should keep running
+func (b *ircbot) activeFeed(feed *feedConfig) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.feeds[feed.name] == feed
+}
+
+func (b *ircbot) feedByName(name string) (*feedConfig, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	feed, ok := b.feeds[name]
+	return feed, ok
+}
+
+// removeFeed forgets the feed added with !news add, its subscriptions and seen items
+func (b *ircbot) removeFeed(ctx context.Context, name string) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	result, err := tx.StmtContext(ctx, b.stmts[deleteFeed]).ExecContext(ctx, name)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		var known bool
+		if err = tx.StmtContext(ctx, b.stmts[hasFeed]).QueryRowContext(ctx, name).Scan(&known); err != nil {
+			return err
+		}
+		if known {
+			return errFeedInConfig
+		}
+		return errUnknownFeed
+	}
+	for _, stmt := range []dbStmt{deleteFeedSubs, deleteFeedSeen, deletePendingFeed} {
+		if _, err = tx.StmtContext(ctx, b.stmts[stmt]).ExecContext(ctx, name); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// pollFeed posts new entries of the feed into its channels. Items present
+// at the very first poll of the feed are taken as already posted.
+func (b *ircbot) pollFeed(feed *feedConfig) error {
+	var state feedState
 	rootctx := b.tomb.Context(nil)
-	timer := time.NewTimer(next)
+	timer := time.NewTimer(firstPollDelay)
 	for {
 		select {
 		case <-b.tomb.Dying():
 			timer.Stop()
 			return tomb.ErrDying
 		case <-timer.C:
-			timer.Reset(time.Hour)
-			ctx, cancel := context.WithTimeout(rootctx, 10*time.Second)
-			line, err := getNewsPost(ctx, "https://t.me/s/neuralmeduza", b.config.userAgent)
-			cancel()
-			if err != nil {
-				b.Logf("Error getting news: %#v", err)
-				continue
-			}
-			if line == oldnews {
+		}
+		if !b.activeFeed(feed) {
+			// deleted with !news del
+			return nil
+		}
+		timer.Reset(feed.interval)
+		// validators are kept only once the items are remembered,
+		// otherwise the next poll would get nothing but 304
+		next := state
+		ctx, cancel := context.WithTimeout(rootctx, b.config.timeout)
+		items, err := fetchNews(ctx, feed, &next, b.config.userAgent)
+		cancel()
+		if err == errNotModified {
+			continue
+		} else if err != nil {
+			b.Logf("Error getting news from %q: %#v", feed.name, err)
+			continue
+		}
+		ctx, cancel = context.WithTimeout(rootctx, b.config.timeout)
+		fresh, rest, err := b.freshNews(ctx, feed.name, items, maxNewsPerPoll)
+		cancel()
+		if err != nil {
+			b.Logf("Failed to check seen news of %q: %q", feed.name, err)
+			continue
+		}
+		if rest > 0 {
+			b.Logf("%d new items in %q, %d are left for the next poll", len(fresh)+rest, feed.name, rest)
+			// unchanged feed must be fetched in full to get them
+			next = feedState{}
+		}
+		state = next
+		if len(fresh) == 0 {
+			continue
+		}
+		ctx, cancel = context.WithTimeout(rootctx, b.config.timeout)
+		err = b.deliverNews(ctx, feed, fresh)
+		cancel()
+		if err != nil {
+			b.Logf("Failed to get subscriptions of %q: %q", feed.name, err)
+		}
+	}
+}
+
+// freshNews returns up to limit oldest items never seen in the feed before
+// and remembers them, rest is the number of new items left unseen for later.
+// Items still listed in the feed are kept past the retention.
+func (b *ircbot) freshNews(ctx context.Context, feed string, items []newsItem, limit int) (fresh []newsItem, rest int, err error) {
+	var known bool
+	now := time.Now()
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	if err = tx.StmtContext(ctx, b.stmts[hasSeenNews]).QueryRowContext(ctx, feed).Scan(&known); err != nil {
+		return nil, 0, err
+	}
+	for _, item := range items {
+		var seen bool
+		err = tx.StmtContext(ctx, b.stmts[seenNews]).QueryRowContext(ctx, feed, item.ID).Scan(&seen)
+		if err != nil {
+			return nil, 0, err
+		}
+		// new feeds start from the next item
+		if known && !seen {
+			if len(fresh) == limit {
+				rest++
 				continue
 			}
-			b.mu.Lock()
-			channel := b.channels["#mania"]
-			b.mu.Unlock()
-			channel.Say(fmt.Sprintf("новости: %s", line))
-			oldnews = line
+			fresh = append(fresh, item)
 		}
+		if _, err = tx.StmtContext(ctx, b.stmts[markNewsSeen]).ExecContext(ctx, feed, item.ID, now.Unix()); err != nil {
+			return nil, 0, err
+		}
+	}
+	if _, err = tx.StmtContext(ctx, b.stmts[pruneSeenNews]).ExecContext(ctx, now.Add(-newsRetention).Unix()); err != nil {
+		return nil, 0, err
 	}
+	return fresh, rest, tx.Commit()
 }
 
-func getNewsPost(ctx context.Context, url string, userAgent string) (string, error) {
-	body, _, err := get(ctx, url, "text/html", userAgent)
-	if err != nil {
-		return "", err
+func (b *ircbot) postNews(feed *feedConfig, channels []string, line string) {
+	if feed.prefix != "" {
+		line = fmt.Sprintf("%s: %s", feed.prefix, line)
 	}
-	defer body.Close()
+	for _, name := range channels {
+		channel, ok := b.joined(name)
+		if !ok {
+			b.Logf("Not in %q, news from %q are not posted", name, feed.name)
+			continue
+		}
+		channel.Say(line)
+	}
+}
 
-	text, err := extractTGLastPost(body)
+func getTGPosts(ctx context.Context, client *httpClient, url string, userAgent string) ([]tgPost, error) {
+	body, _, err := client.get(ctx, url, "text/html", userAgent)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return text, nil
+	defer body.Close()
+
+	return extractTGPosts(body)
 }
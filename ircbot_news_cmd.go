@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"gitea.demsh.org/demsh/ircfw"
+)
+
+const (
+	newsUsage = "Usage: !news list | !news sub <feed> | !news unsub <feed> | !news last <feed> | !news filter|digest|quiet <feed> ... | !news add <name> <url> | !news del <name>"
+)
+
+var (
+	validFeedName = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+)
+
+func handleNews(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	params := strings.Fields(msg.Text()[0])[1:]
+	if len(params) < 1 {
+		serveNewsList(ctx, bot, msg)
+		return
+	}
+	args := params[1:]
+	switch strings.ToLower(params[0]) {
+	case "list":
+		serveNewsList(ctx, bot, msg)
+	case "last":
+		serveNewsLast(ctx, bot, msg, args)
+	case "sub":
+		if !bot.isChannelOp(msg) {
+			return
+		}
+		serveNewsSub(ctx, bot, msg, args, insertNewsSub)
+	case "unsub":
+		if !bot.isChannelOp(msg) {
+			return
+		}
+		serveNewsSub(ctx, bot, msg, args, deleteNewsSub)
+	case "filter", "digest", "quiet":
+		if !bot.isChannelOp(msg) {
+			return
+		}
+		serveNewsSettings(ctx, bot, msg, strings.ToLower(params[0]), args)
+	case "add":
+		if !bot.isAdmin(msg.Prefix()) {
+			return
+		}
+		serveNewsAdd(ctx, bot, msg, args)
+	case "del":
+		if !bot.isAdmin(msg.Prefix()) {
+			return
+		}
+		serveNewsDelete(ctx, bot, msg, args)
+	default:
+		msg.Reply(ctx, []string{newsUsage})
+	}
+}
+
+// serveNewsList lists feeds marking the ones the channel is subscribed to
+func serveNewsList(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	feeds, err := bot.storedFeeds(ctx)
+	if err != nil {
+		bot.Logf("Failed to list feeds: %q", err)
+		return
+	}
+	if len(feeds) == 0 {
+		msg.Reply(ctx, []string{"No news feeds"})
+		return
+	}
+	var subscribed []string
+	if !msg.IsPrivate() {
+		subscribed, err = bot.channelFeeds(ctx, strings.ToLower(msg.Channel().Name()))
+		if err != nil {
+			bot.Logf("Failed to list subscriptions: %q", err)
+			return
+		}
+	}
+	names := make([]string, 0, len(feeds))
+	for _, feed := range feeds {
+		if contains(subscribed, feed.name) {
+			names = append(names, feed.name+"*")
+			continue
+		}
+		names = append(names, feed.name)
+	}
+	reply := fmt.Sprintf("Feeds: %s", strings.Join(names, ", "))
+	if len(subscribed) != 0 {
+		reply += fmt.Sprintf(" (* subscribed in %s)", msg.Channel().Name())
+	}
+	msg.Reply(ctx, []string{reply})
+}
+
+func (b *ircbot) channelFeeds(ctx context.Context, channel string) (feeds []string, err error) {
+	rows, err := b.stmts[channelNewsSubs].QueryContext(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var feed string
+		if err = rows.Scan(&feed); err != nil {
+			return nil, err
+		}
+		feeds = append(feeds, feed)
+	}
+	return feeds, rows.Err()
+}
+
+// serveNewsSub subscribes or unsubscribes the channel depending on stmt
+func serveNewsSub(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string, stmt dbStmt) {
+	if msg.IsPrivate() {
+		msg.Reply(ctx, []string{"Subscriptions are managed in channels"})
+		return
+	}
+	if len(params) != 1 {
+		msg.Reply(ctx, []string{newsUsage})
+		return
+	}
+	name := strings.ToLower(params[0])
+	if _, ok := bot.feedByName(name); !ok {
+		msg.Reply(ctx, []string{fmt.Sprintf("No feed %q", name)})
+		return
+	}
+	// subscriptions are stored with lowercase channel names
+	channel := strings.ToLower(msg.Channel().Name())
+	result, err := bot.stmts[stmt].ExecContext(ctx, name, channel)
+	if err != nil {
+		bot.Logf("Failed to change subscription of %q to %q: %q", channel, name, err)
+		return
+	}
+	n, _ := result.RowsAffected()
+	if stmt == deleteNewsSub {
+		if _, err = bot.stmts[deletePendingNews].ExecContext(ctx, name, channel); err != nil {
+			bot.Logf("Failed to drop held news of %q for %q: %q", name, channel, err)
+		}
+	}
+	switch {
+	case stmt == insertNewsSub && n == 0:
+		msg.Reply(ctx, []string{fmt.Sprintf("%s is already subscribed to %s", channel, name)})
+	case stmt == insertNewsSub:
+		msg.Reply(ctx, []string{fmt.Sprintf("Subscribed %s to %s", channel, name)})
+	case n == 0:
+		msg.Reply(ctx, []string{fmt.Sprintf("%s is not subscribed to %s", channel, name)})
+	default:
+		msg.Reply(ctx, []string{fmt.Sprintf("Unsubscribed %s from %s", channel, name)})
+	}
+}
+
+// serveNewsLast fetches the feed and shows its most recent item
+func serveNewsLast(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	if len(params) != 1 {
+		msg.Reply(ctx, []string{newsUsage})
+		return
+	}
+	name := strings.ToLower(params[0])
+	feed, ok := bot.feedByName(name)
+	if !ok {
+		msg.Reply(ctx, []string{fmt.Sprintf("No feed %q", name)})
+		return
+	}
+	items, err := fetchNews(ctx, feed, &feedState{}, bot.config.userAgent)
+	if err != nil {
+		bot.Logf("Error getting news from %q: %#v", name, err)
+		msg.Reply(ctx, []string{fmt.Sprintf("Failed to get %s", name)})
+		return
+	}
+	if len(items) == 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("%s is empty", name)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("%s: %s", name, items[len(items)-1].format(feed.summary))})
+}
+
+func serveNewsAdd(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	if len(params) != 2 {
+		msg.Reply(ctx, []string{newsUsage})
+		return
+	}
+	name, rawURL := strings.ToLower(params[0]), params[1]
+	if !validFeedName.MatchString(name) {
+		msg.Reply(ctx, []string{"Feed names are up to 32 of a-z, 0-9, _ and -"})
+		return
+	}
+	if u, err := url.Parse(rawURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		msg.Reply(ctx, []string{fmt.Sprintf("%q is not valid http(s) URL", rawURL)})
+		return
+	}
+	result, err := bot.stmts[insertFeed].ExecContext(ctx, name, rawURL)
+	if err != nil {
+		bot.Logf("Failed to add feed %q: %q", name, err)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		msg.Reply(ctx, []string{fmt.Sprintf("Feed %q already exists", name)})
+		return
+	}
+	bot.startFeed(newFeed(name, rawURL))
+	msg.Reply(ctx, []string{fmt.Sprintf("Added feed %s, subscribe channels with !news sub %s", name, name)})
+}
+
+func serveNewsDelete(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	if len(params) != 1 {
+		msg.Reply(ctx, []string{newsUsage})
+		return
+	}
+	name := strings.ToLower(params[0])
+	err := bot.removeFeed(ctx, name)
+	switch {
+	case err == errFeedInConfig:
+		msg.Reply(ctx, []string{fmt.Sprintf("Feed %s is defined in the config file", name)})
+	case err == errUnknownFeed:
+		msg.Reply(ctx, []string{fmt.Sprintf("No feed %q", name)})
+	case err != nil:
+		bot.Logf("Failed to delete feed %q: %q", name, err)
+	default:
+		bot.stopFeed(name)
+		msg.Reply(ctx, []string{fmt.Sprintf("Deleted feed %s", name)})
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gitea.demsh.org/demsh/ircfw"
+	"gopkg.in/tomb.v2"
+)
+
+const (
+	newsFilterUsage = "Usage: !news filter <feed> [include|exclude <word,/regexp/,...> | clear] | !news digest <feed> <09:00,18:00>|off | !news quiet <feed> <23:00-08:00>|off"
+	// digest lines longer than that are cut
+	maxDigestLen = 400
+	// item titles in digests are cut to that length
+	maxDigestTitle = 80
+)
+
+var (
+	errNotSubscribed = errors.New("channel is not subscribed")
+)
+
+// newsSub is the subscription of a channel to a feed with its settings
+// kept as entered, they are validated before saving
+type newsSub struct {
+	feed, channel    string
+	include, exclude string
+	digest, quiet    string
+	flushed          time.Time
+}
+
+type newsTerm struct {
+	word string
+	re   *regexp.Regexp
+}
+
+// parseTerms parses comma separated words and /regexps/, matching is case insensitive
+func parseTerms(value string) (terms []newsTerm, err error) {
+	for _, term := range splitTrim(value, ",") {
+		if term == "" {
+			continue
+		}
+		if len(term) > 2 && strings.HasPrefix(term, "/") && strings.HasSuffix(term, "/") {
+			re, err := regexp.Compile("(?i)" + term[1:len(term)-1])
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, newsTerm{re: re})
+			continue
+		}
+		terms = append(terms, newsTerm{word: strings.ToLower(term)})
+	}
+	return
+}
+
+func matchTerms(terms []newsTerm, text string) bool {
+	lower := strings.ToLower(text)
+	for _, term := range terms {
+		if term.re != nil && term.re.MatchString(text) {
+			return true
+		}
+		if term.re == nil && strings.Contains(lower, term.word) {
+			return true
+		}
+	}
+	return false
+}
+
+// passes applies include and exclude filters to the title and summary of item
+func (s newsSub) passes(item newsItem) bool {
+	text := item.Title + " " + item.Summary
+	include, _ := parseTerms(s.include)
+	if len(include) != 0 && !matchTerms(include, text) {
+		return false
+	}
+	exclude, _ := parseTerms(s.exclude)
+	return !matchTerms(exclude, text)
+}
+
+// parseDigest parses comma separated 15:04 times
+func parseDigest(value string) (ats []time.Duration, err error) {
+	for _, clock := range splitTrim(value, ",") {
+		at, err := parseClock(clock)
+		if err != nil {
+			return nil, err
+		}
+		ats = append(ats, at)
+	}
+	sort.Slice(ats, func(i, j int) bool { return ats[i] < ats[j] })
+	return
+}
+
+// parseQuiet parses 23:00-08:00 range, which may span midnight
+func parseQuiet(value string) (from, to time.Duration, err error) {
+	clocks := splitTrim(value, "-")
+	if len(clocks) != 2 {
+		return 0, 0, fmt.Errorf("%q is not a range", value)
+	}
+	if from, err = parseClock(clocks[0]); err != nil {
+		return
+	}
+	to, err = parseClock(clocks[1])
+	return
+}
+
+func (s newsSub) isQuiet(now time.Time) bool {
+	if s.quiet == "" {
+		return false
+	}
+	from, to, err := parseQuiet(s.quiet)
+	if err != nil {
+		return false
+	}
+	at := sinceMidnight(now)
+	if from <= to {
+		return from <= at && at < to
+	}
+	return at >= from || at < to
+}
+
+// holds tells whether items are kept for later instead of being posted now
+func (s newsSub) holds(now time.Time) bool {
+	return s.digest != "" || s.isQuiet(now)
+}
+
+// due tells whether held items should be posted now
+func (s newsSub) due(now time.Time) bool {
+	if s.isQuiet(now) {
+		return false
+	}
+	if s.digest == "" {
+		// quiet hours are over
+		return true
+	}
+	ats, err := parseDigest(s.digest)
+	if err != nil {
+		return false
+	}
+	return lastDigest(now, ats).After(s.flushed)
+}
+
+// lastDigest returns the latest scheduled moment not after now
+func lastDigest(now time.Time, ats []time.Duration) (last time.Time) {
+	for _, at := range ats {
+		t := onDay(now, 0, at)
+		if t.After(now) {
+			t = onDay(now, -1, at)
+		}
+		if t.After(last) {
+			last = t
+		}
+	}
+	return
+}
+
+func scanNewsSub(scan func(...interface{}) error) (s newsSub, err error) {
+	var flushed int64
+	err = scan(&s.feed, &s.channel, &s.include, &s.exclude, &s.digest, &s.quiet, &flushed)
+	s.flushed = time.Unix(flushed, 0)
+	return
+}
+
+func (b *ircbot) newsSubs(ctx context.Context, stmt dbStmt, args ...interface{}) (subs []newsSub, err error) {
+	rows, err := b.stmts[stmt].QueryContext(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sub, err := scanNewsSub(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// deliverNews posts fresh items to subscribed channels or holds them
+// for digests and the end of quiet hours
+func (b *ircbot) deliverNews(ctx context.Context, feed *feedConfig, items []newsItem) error {
+	now := time.Now()
+	subs, err := b.newsSubs(ctx, feedNewsSubs, feed.name)
+	if err != nil {
+		return err
+	}
+	for _, sub := range subs {
+		for _, item := range items {
+			if !sub.passes(item) {
+				continue
+			}
+			if !sub.holds(now) {
+				b.postNews(feed, []string{sub.channel}, item.format(feed.summary))
+				continue
+			}
+			title := firstNonEmpty(item.Title, item.Summary)
+			_, err = b.stmts[insertPendingNews].ExecContext(ctx,
+				feed.name, sub.channel, trimRunes(title, maxDigestTitle), now.Unix())
+			if err != nil {
+				b.Logf("Failed to hold news of %q for %q: %q", feed.name, sub.channel, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flushNews posts held items as single digest lines when they are due
+func (b *ircbot) flushNews() error {
+	rootctx := b.tomb.Context(nil)
+	ticker := time.NewTicker(time.Minute)
+	for {
+		select {
+		case <-b.tomb.Dying():
+			ticker.Stop()
+			return tomb.ErrDying
+		case <-ticker.C:
+		}
+		ctx, cancel := context.WithTimeout(rootctx, b.config.timeout)
+		subs, err := b.newsSubs(ctx, heldNewsSubs)
+		if err != nil {
+			b.Logf("Failed to get news subscriptions: %q", err)
+		}
+		now := time.Now()
+		for _, sub := range subs {
+			if !sub.due(now) {
+				continue
+			}
+			if err = b.flushSub(ctx, sub, now); err != nil {
+				b.Logf("Failed to post digest of %q to %q: %q", sub.feed, sub.channel, err)
+			}
+		}
+		cancel()
+	}
+}
+
+// pendingNews returns titles held for the channel and id of the last of them
+func pendingNews(ctx context.Context, stmt *sql.Stmt, feed, channel string) (lastID int64, titles []string, err error) {
+	rows, err := stmt.QueryContext(ctx, feed, channel)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var title string
+		if err = rows.Scan(&lastID, &title); err != nil {
+			return 0, nil, err
+		}
+		titles = append(titles, title)
+	}
+	return lastID, titles, rows.Err()
+}
+
+// flushSub posts held items of the subscription as one line. Items stay held
+// while the feed is stopped or the bot is not in the channel.
+func (b *ircbot) flushSub(ctx context.Context, sub newsSub, now time.Time) error {
+	feed, ok := b.feedByName(sub.feed)
+	if !ok {
+		return nil
+	}
+	if _, ok = b.joined(sub.channel); !ok {
+		return nil
+	}
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	lastID, titles, err := pendingNews(ctx, tx.StmtContext(ctx, b.stmts[listPendingNews]), sub.feed, sub.channel)
+	if err != nil {
+		return err
+	}
+	if len(titles) == 0 && sub.digest == "" {
+		return nil
+	}
+	if _, err = tx.StmtContext(ctx, b.stmts[flushedNewsSub]).ExecContext(ctx, now.Unix(), sub.feed, sub.channel); err != nil {
+		return err
+	}
+	// items held after the read are left for the next digest
+	_, err = tx.StmtContext(ctx, b.stmts[flushPendingNews]).ExecContext(ctx, sub.feed, sub.channel, lastID)
+	if err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	if len(titles) == 0 {
+		return nil
+	}
+	line := titles[0]
+	if len(titles) > 1 {
+		line = fmt.Sprintf("%d new items: %s", len(titles), strings.Join(titles, " | "))
+	}
+	b.postNews(feed, []string{sub.channel}, trimRunes(line, maxDigestLen))
+	return nil
+}
+
+// changeNewsSub applies change to the subscription of the channel to the feed
+func (b *ircbot) changeNewsSub(ctx context.Context, feed, channel string, change func(*newsSub)) error {
+	sub, err := scanNewsSub(b.stmts[fetchNewsSub].QueryRowContext(ctx, feed, channel).Scan)
+	if err == sql.ErrNoRows {
+		return errNotSubscribed
+	} else if err != nil {
+		return err
+	}
+	change(&sub)
+	_, err = b.stmts[updateNewsSub].ExecContext(ctx, sub.include, sub.exclude, sub.digest, sub.quiet, feed, channel)
+	return err
+}
+
+// serveNewsSettings handles filter, digest and quiet subcommands
+func serveNewsSettings(ctx context.Context, bot *ircbot, msg ircfw.Msg, setting string, params []string) {
+	if msg.IsPrivate() {
+		msg.Reply(ctx, []string{"Subscriptions are managed in channels"})
+		return
+	}
+	if len(params) < 1 {
+		msg.Reply(ctx, []string{newsFilterUsage})
+		return
+	}
+	feed, channel := strings.ToLower(params[0]), strings.ToLower(msg.Channel().Name())
+	params = params[1:]
+	var change func(*newsSub)
+	switch {
+	case setting == "filter" && len(params) == 0:
+		serveNewsSettingsShow(ctx, bot, msg, feed, channel)
+		return
+	case setting == "filter" && len(params) == 1 && strings.ToLower(params[0]) == "clear":
+		change = func(s *newsSub) { s.include, s.exclude = "", "" }
+	case setting == "filter" && len(params) > 1:
+		value := strings.Join(params[1:], " ")
+		if _, err := parseTerms(value); err != nil {
+			msg.Reply(ctx, []string{fmt.Sprintf("Invalid filter: %s", err)})
+			return
+		}
+		switch strings.ToLower(params[0]) {
+		case "include":
+			change = func(s *newsSub) { s.include = value }
+		case "exclude":
+			change = func(s *newsSub) { s.exclude = value }
+		}
+	case len(params) == 1 && strings.ToLower(params[0]) == "off":
+		change = func(s *newsSub) {
+			if setting == "digest" {
+				s.digest = ""
+			} else {
+				s.quiet = ""
+			}
+		}
+	case setting == "digest" && len(params) == 1:
+		if _, err := parseDigest(params[0]); err != nil {
+			msg.Reply(ctx, []string{newsFilterUsage})
+			return
+		}
+		change = func(s *newsSub) { s.digest = params[0] }
+	case setting == "quiet" && len(params) == 1:
+		if _, _, err := parseQuiet(params[0]); err != nil {
+			msg.Reply(ctx, []string{newsFilterUsage})
+			return
+		}
+		change = func(s *newsSub) { s.quiet = params[0] }
+	}
+	if change == nil {
+		msg.Reply(ctx, []string{newsFilterUsage})
+		return
+	}
+	err := bot.changeNewsSub(ctx, feed, channel, change)
+	switch {
+	case err == errNotSubscribed:
+		msg.Reply(ctx, []string{fmt.Sprintf("%s is not subscribed to %s", channel, feed)})
+	case err != nil:
+		bot.Logf("Failed to change subscription of %q to %q: %q", channel, feed, err)
+	default:
+		serveNewsSettingsShow(ctx, bot, msg, feed, channel)
+	}
+}
+
+func serveNewsSettingsShow(ctx context.Context, bot *ircbot, msg ircfw.Msg, feed, channel string) {
+	sub, err := scanNewsSub(bot.stmts[fetchNewsSub].QueryRowContext(ctx, feed, channel).Scan)
+	if err == sql.ErrNoRows {
+		msg.Reply(ctx, []string{fmt.Sprintf("%s is not subscribed to %s", channel, feed)})
+		return
+	} else if err != nil {
+		bot.Logf("Failed to get subscription of %q to %q: %q", channel, feed, err)
+		return
+	}
+	settings := []string{
+		fmt.Sprintf("include: %s", firstNonEmpty(sub.include, "-")),
+		fmt.Sprintf("exclude: %s", firstNonEmpty(sub.exclude, "-")),
+		fmt.Sprintf("digest: %s", firstNonEmpty(sub.digest, "off")),
+		fmt.Sprintf("quiet: %s", firstNonEmpty(sub.quiet, "off")),
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("%s in %s: %s", feed, channel, strings.Join(settings, ", "))})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/tomb.v2"
+)
+
+var pageTemplate = template.Must(template.New("quote").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>#{{.Id}}</title></head>
+<body>
+<p><b>#{{.Id}}</b> :: {{.Date.Format "2006-01-02 15:04"}} :: Rating: {{.Rating}}</p>
+<pre>{{range .Text}}{{.}}
+{{end}}</pre>
+</body>
+</html>
+`))
+
+// pasteLink returns link to the page with the quote or empty string
+// if the paste server isn't configured
+func (b *ircbot) pasteLink(id int) string {
+	if b.config.pasteListen == "" || b.config.pasteURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(b.config.pasteURL, "/") + "/quote/" + strconv.Itoa(id)
+}
+
+func (b *ircbot) servePaste() error {
+	listener, err := net.Listen("tcp", b.config.pasteListen)
+	if err != nil {
+		b.Logf("Failed to listen on %q: %q", b.config.pasteListen, err)
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/quote/", b.handlePaste)
+	server := http.Server{
+		Handler:      mux,
+		ReadTimeout:  b.config.timeout,
+		WriteTimeout: b.config.timeout,
+		BaseContext: func(net.Listener) context.Context {
+			return b.tomb.Context(nil)
+		},
+	}
+	b.tomb.Go(func() error {
+		<-b.tomb.Dying()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(ctx)
+		cancel()
+		return tomb.ErrDying
+	})
+	err = server.Serve(listener)
+	if errors.Is(err, http.ErrServerClosed) {
+		return tomb.ErrDying
+	}
+	return err
+}
+
+func (b *ircbot) handlePaste(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/quote/"))
+	if err != nil || id <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	q, err := b.fetchQuote(r.Context(), id)
+	if err == quoteNotFound || err == quoteDeleted {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		b.Logf("Failed to get quote for paste page: %#v", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err = pageTemplate.Execute(w, q); err != nil {
+		b.Debug("Failed to render quote %d: %q", id, err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gitea.demsh.org/demsh/ircfw"
+)
+
+const (
+	prefCity     = "city"
+	prefUnits    = "units"
+	prefLang     = "lang"
+	prefCurrency = "currency"
+	setUsage     = "Usage: !set city <alias> | !set units metric|imperial | !set lang ru|en | !set currency <code>"
+)
+
+// prefValidators check values of known preferences, empty value resets the preference
+var prefValidators = map[string]func(string) bool{
+	prefCity: func(value string) bool {
+		return len(value) <= 64
+	},
+	prefUnits: func(value string) bool {
+		return value == unitsMetric || value == unitsImperial
+	},
+	prefLang: func(value string) bool {
+		return value == langRu || value == langEn
+	},
+	prefCurrency: func(value string) bool {
+		return validSymbol(strings.ToUpper(value))
+	},
+}
+
+type userPrefs map[string]string
+
+// accountMsg is implemented by messages which carry the services account
+// of the sender, e.g. from the IRCv3 account-tag
+type accountMsg interface {
+	Account() string
+}
+
+// userKey identifies the sender of msg for per-user data. Services account
+// survives host changes, so it's preferred when the client reports it,
+// otherwise ident@host is used.
+func userKey(msg ircfw.Msg) string {
+	if m, ok := msg.(accountMsg); ok {
+		// "*" is sent for users who aren't logged in
+		if name := m.Account(); name != "" && name != "*" {
+			// prefixed to never collide with nicks, the last resort key
+			return "$a:" + name
+		}
+	}
+	if user := account(msg.Prefix()); user != "" {
+		return user
+	}
+	return msg.Nick()
+}
+
+func (b *ircbot) loadPrefs(ctx context.Context, user string) (userPrefs, error) {
+	prefs := make(userPrefs)
+	rows, err := b.stmts[fetchPrefs].QueryContext(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		prefs[key] = value
+	}
+	return prefs, rows.Err()
+}
+
+// prefs returns preferences of the sender of msg, failures are logged
+// and result in defaults
+func (b *ircbot) prefs(ctx context.Context, msg ircfw.Msg) userPrefs {
+	prefs, err := b.loadPrefs(ctx, userKey(msg))
+	if err != nil {
+		b.Logf("Failed to load preferences of %q: %q", userKey(msg), err)
+		return userPrefs{}
+	}
+	return prefs
+}
+
+func handleSet(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
+	var err error
+	params := strings.Fields(msg.Text()[0])[1:]
+	user := userKey(msg)
+	if len(params) == 0 {
+		prefs, err := bot.loadPrefs(ctx, user)
+		if err != nil {
+			bot.Logf("Failed to load preferences of %q: %q", user, err)
+			return
+		}
+		if len(prefs) == 0 {
+			msg.Reply(ctx, []string{setUsage})
+			return
+		}
+		var values []string
+		for _, key := range []string{prefCity, prefUnits, prefLang, prefCurrency} {
+			if value, ok := prefs[key]; ok {
+				values = append(values, fmt.Sprintf("%s: %s", key, value))
+			}
+		}
+		msg.Reply(ctx, []string{strings.Join(values, ", ")})
+		return
+	}
+	key := strings.ToLower(params[0])
+	valid, ok := prefValidators[key]
+	if !ok {
+		msg.Reply(ctx, []string{setUsage})
+		return
+	}
+	value := strings.ToLower(strings.Join(params[1:], " "))
+	if key == prefCurrency {
+		value = strings.ToUpper(value)
+	}
+	if value == "" {
+		_, err = bot.stmts[deletePref].ExecContext(ctx, user, key)
+	} else {
+		if !valid(value) {
+			msg.Reply(ctx, []string{setUsage})
+			return
+		}
+		_, err = bot.stmts[upsertPref].ExecContext(ctx, user, key, value)
+	}
+	if err != nil {
+		bot.Logf("Failed to save preference %q of %q: %q", key, user, err)
+		return
+	}
+	if value == "" {
+		msg.Reply(ctx, []string{fmt.Sprintf("%s reset", key)})
+		return
+	}
+	msg.Reply(ctx, []string{fmt.Sprintf("%s set to %s", key, value)})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"gitea.demsh.org/demsh/ircfw"
+	"gopkg.in/tomb.v2"
+)
+
+const (
+	qotdDayFormat = "2006-01-02"
+)
+
+// nextQOTD returns the moment of the next posting after now
+func nextQOTD(now time.Time, at time.Duration) time.Time {
+	next := onDay(now, 0, at)
+	if !next.After(now) {
+		next = onDay(now, 1, at)
+	}
+	return next
+}
+
+func (b *ircbot) postQOTD(schedule qotdSchedule) error {
+	rootctx := b.tomb.Context(nil)
+	timer := time.NewTimer(time.Until(nextQOTD(time.Now(), schedule.at)))
+	for {
+		select {
+		case <-b.tomb.Dying():
+			timer.Stop()
+			return tomb.ErrDying
+		case <-timer.C:
+		}
+		timer.Reset(time.Until(nextQOTD(time.Now(), schedule.at)))
+		ctx, cancel := context.WithTimeout(rootctx, b.config.timeout)
+		q, fresh, err := b.pickQOTD(ctx, schedule.channel)
+		cancel()
+		if err != nil {
+			b.Logf("Failed to pick quote of the day for %q: %#v", schedule.channel, err)
+			continue
+		}
+		// already posted today before restart
+		if !fresh {
+			continue
+		}
+		channel, ok := b.joined(schedule.channel)
+		if !ok {
+			b.Logf("Not in %q, quote of the day is not posted", schedule.channel)
+			continue
+		}
+		preview, rest := b.config.previewQuote(schedule.channel, q.ircFormat())
+		lines := append([]string{"Quote of the day:"}, preview...)
+		if rest > 0 {
+			if link := b.pasteLink(q.Id); link != "" {
+				lines = append(lines, fmt.Sprintf("... %d more lines: %s", rest, link))
+			} else {
+				lines = append(lines, fmt.Sprintf("... %d more lines: !bash %d", rest, q.Id))
+			}
+		}
+		for _, line := range lines {
+			channel.Say(line)
+		}
+	}
+}
+
+// todayQOTD returns the quote picked for the channel today
+func (b *ircbot) todayQOTD(ctx context.Context, channel string) (quote, error) {
+	var id int
+	day := time.Now().Format(qotdDayFormat)
+	err := b.stmts[fetchQOTD].QueryRowContext(ctx, channel, day).Scan(&id)
+	if err == sql.ErrNoRows {
+		return quote{}, quoteNotFound
+	} else if err != nil {
+		return quote{}, err
+	}
+	return b.fetchQuote(ctx, id)
+}
+
+// pickQOTD chooses today's quote for the channel unless it's already chosen,
+// fresh reports whether the quote was picked by this call
+func (b *ircbot) pickQOTD(ctx context.Context, channel string) (q quote, fresh bool, err error) {
+	q, err = b.todayQOTD(ctx, channel)
+	if err != quoteNotFound {
+		return q, false, err
+	}
+	now := time.Now()
+	since := now.AddDate(0, 0, -b.config.qotdHistory).Format(qotdDayFormat)
+	q, err = scanQuote(b.stmts[fetchRandomQOTD].QueryRowContext(ctx, b.config.qotdRating, channel, since))
+	if err == quoteNotFound {
+		// every quote rated high enough was posted recently
+		q, err = b.fetchRandomRatingQuote(ctx, b.config.qotdRating)
+	}
+	if err != nil {
+		return quote{}, false, err
+	}
+	_, err = b.stmts[insertQOTD].ExecContext(ctx, channel, now.Format(qotdDayFormat), q.Id)
+	if err != nil {
+		return quote{}, false, err
+	}
+	return q, true, nil
+}
+
+func serveQOTD(ctx context.Context, bot *ircbot, msg ircfw.Msg, params []string) {
+	var channel string
+	switch {
+	case len(params) > 0:
+		channel = strings.ToLower(params[0])
+	case !msg.IsPrivate():
+		channel = strings.ToLower(msg.Channel().Name())
+	default:
+		msg.Reply(ctx, []string{"Usage: !bash qotd #channel"})
+		return
+	}
+	q, err := bot.todayQOTD(ctx, channel)
+	if err == quoteNotFound {
+		msg.Reply(ctx, []string{fmt.Sprintf("No quote of the day for %s yet", channel)})
+		return
+	} else if err == quoteDeleted {
+		msg.Reply(ctx, []string{fmt.Sprintf("Quote of the day for %s was deleted", channel)})
+		return
+	} else if err != nil {
+		bot.Logf("Failed to get quote of the day: %#v", err)
+		return
+	}
+	sendQuote(ctx, bot, msg, q)
+}
@@ -17,6 +17,20 @@ func handleStatus(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 		return
 	}
 	runtime.ReadMemStats(&m)
-	msg.Reply(ctx, []string{fmt.Sprintf("goroutines: %d, heap: %d KB, GC runs: %d, runtime: %s",
-		runtime.NumGoroutine(), m.HeapAlloc/1024, m.NumGC, runtime.Version())})
+	msg.Reply(ctx, []string{
+		fmt.Sprintf("goroutines: %d, heap: %d KB, GC runs: %d, runtime: %s",
+			runtime.NumGoroutine(), m.HeapAlloc/1024, m.NumGC, runtime.Version()),
+		fmt.Sprintf("cache hits/stale/misses/size: weather %s, forecast %s, geo %s, prices %s, history %s",
+			cacheStats(bot.weatherCache), cacheStats(bot.forecastCache), cacheStats(bot.geoCache),
+			cacheStats(bot.currencyCache), cacheStats(bot.historyCache)),
+	})
+}
+
+type statsReporter interface {
+	Stats() (hits, staleHits, misses uint64, size int)
+}
+
+func cacheStats(cache statsReporter) string {
+	hits, staleHits, misses, size := cache.Stats()
+	return fmt.Sprintf("%d/%d/%d/%d", hits, staleHits, misses, size)
 }
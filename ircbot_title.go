@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
 	"regexp"
 	"strings"
@@ -13,6 +12,11 @@ import (
 	"gitea.demsh.org/demsh/ircfw"
 )
 
+const (
+	previewTitle = "title"
+	previewRich  = "rich"
+)
+
 var (
 	isUrl       = regexp.MustCompile(`https?://[^\s]{1,500}`)
 	ignoredExts = []string{
@@ -58,7 +62,7 @@ func handleURL(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 		if isIgnored(ctx, bot, url) {
 			continue
 		}
-		title, err := getTitle(ctx, url, bot.config.userAgent)
+		title, err := getTitle(ctx, bot.titleFetch(msg), url)
 		if err != nil {
 			bot.Logf("Failed to extract title from %q, err: %q", url, err)
 			continue
@@ -74,50 +78,96 @@ func handleURL(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
 	}
 }
 
-func getTitle(ctx context.Context, url string, userAgent string) (title string, err error) {
-	body, utf8, err := get(ctx, url, "text/html", userAgent)
+// titleFetch uses the guarded client, rich previews are configured per channel
+func (b *ircbot) titleFetch(msg ircfw.Msg) titleFetch {
+	f := titleFetch{client: b.titleClient, userAgent: b.config.userAgent, sites: b.titleSites}
+	if !msg.IsPrivate() {
+		f.rich = b.config.titlePreview[strings.ToLower(msg.Channel().Name())] == previewRich
+	}
+	return f
+}
+
+// getTitle asks the extractor of the site if there is one, pages it doesn't
+// know or fails on get the generic title
+func getTitle(ctx context.Context, f titleFetch, rawURL string) (title string, err error) {
+	link, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if extractor, ok := f.lookupSite(link.Hostname()); ok {
+		if title, err = extractor(ctx, f, link); err == nil {
+			return title, nil
+		}
+	}
+	body, utf8, err := f.client.get(ctx, rawURL, "text/html", f.userAgent)
 	if err != nil {
 		return "", err
 	}
 	defer body.Close()
 
-	title, err = extractTitle(body, utf8)
+	title, err = extractTitle(body, utf8, f.rich)
 	if err != nil {
 		return "", err
 	}
 	return title, nil
 }
 
+func getTGTitle(ctx context.Context, f titleFetch, url string) (string, error) {
+	posts, err := getTGPosts(ctx, f.client, url, f.userAgent)
+	if err != nil {
+		return "", err
+	}
+	return posts[len(posts)-1].String(), nil
+}
+
 func get(ctx context.Context, url string, contentType string, userAgent string) (body io.ReadCloser, utf8 bool, err error) {
-	var client http.Client
+	return sharedClient.get(ctx, url, contentType, userAgent)
+}
+
+func getWith(ctx context.Context, url string, contentType string, userAgent string, header http.Header) (response *http.Response, utf8 bool, err error) {
+	return sharedClient.getWith(ctx, url, contentType, userAgent, header)
+}
+
+func (c *httpClient) get(ctx context.Context, url string, contentType string, userAgent string) (body io.ReadCloser, utf8 bool, err error) {
+	response, utf8, err := c.getWith(ctx, url, contentType, userAgent, nil)
+	if err != nil {
+		return
+	}
+	body = response.Body
+	return
+}
+
+// getWith sends extra header with the request. Responses other than 200 are
+// errors, except 304 answering conditional requests.
+func (c *httpClient) getWith(ctx context.Context, url string, contentType string, userAgent string, header http.Header) (response *http.Response, utf8 bool, err error) {
 	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return
 	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
 	if userAgent != "" {
 		request.Header.Set("User-Agent", userAgent)
 	}
-	jar, err := cookiejar.New(nil)
+	response, err = c.do(request)
 	if err != nil {
 		return
 	}
-	client.Jar = jar
-	response, err := client.Do(request)
-	if err != nil {
+	if response.StatusCode == http.StatusNotModified && len(header) != 0 {
 		return
 	}
 	if response.StatusCode != http.StatusOK {
 		response.Body.Close()
 		err = fmt.Errorf("wrong status code %d", response.StatusCode)
-		return
+		return nil, false, err
 	}
 	responseType := response.Header.Get("Content-Type")
 	var ok bool
 	if ok, utf8 = checkContentType(responseType, contentType); !ok {
 		response.Body.Close()
 		err = fmt.Errorf("Content-Type %q is not %q", responseType, contentType)
-		return
+		return nil, false, err
 	}
-	body = response.Body
 	return
 }
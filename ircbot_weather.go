@@ -2,120 +2,75 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
 	"strings"
-	"time"
 
 	"gitea.demsh.org/demsh/ircfw"
-	"gopkg.in/tomb.v2"
 )
 
-const (
-	weatherURL = "https://api.openweathermap.org/data/2.5/weather?units=metric&lang=ru&APPID=%s&q=%s"
-	mmHgMagic  = 0.750062
-)
-
-type weather struct {
-	Weather []struct {
-		Description string `json:"description"`
-	} `json:"weather"`
-	Main struct {
-		Temp     float64 `json:"temp"`
-		Pressure float64 `json:"pressure"`
-		Humidity int     `json:"humidity"`
-		TempMin  float64 `json:"temp_min"`
-		TempMax  float64 `json:"temp_max"`
-	} `json:"main"`
-	Wind struct {
-		Speed float64 `json:"speed"`
-		Deg   float64 `json:"deg"`
-	} `json:"wind"`
-	Sys struct {
-		Country string `json:"country"`
-	} `json:"sys"`
-	CityID  int    `json:"id"`
-	Name    string `json:"name"`
-	Cod     int    `json:"cod"`
-	created time.Time
-}
-
-func (w weather) expired() bool {
-	return time.Since(w.created) > 10*time.Minute
-}
-
-func (w weather) String() string {
-	return fmt.Sprintf("%s/%s: %s; температура: %.1f °C; давление: %.1f мм рт.ст; ветер %.1f м/с, относ. влаж.: %d%%",
-		w.Name, w.Sys.Country, w.Weather[0].Description,
-		w.Main.Temp, w.Main.Pressure*mmHgMagic, w.Wind.Speed,
-		w.Main.Humidity)
-}
-
 func handleWeather(ctx context.Context, bot *ircbot, msg ircfw.Msg) {
-	params := strings.Split(removeCmd(msg.Text(), cmdWeather)[0], " ")
-	if len(params) < 1 {
-		return
+	params := strings.Fields(removeCmd(msg.Text(), cmdWeather)[0])
+	prefs := bot.prefs(ctx, msg)
+	format := bot.weatherFormat(msg, prefs)
+	if len(params) < 1 || strings.ToLower(params[0]) == "завтра" {
+		// the city saved with !set city
+		city, ok := prefs[prefCity]
+		if !ok {
+			msg.Reply(ctx, []string{"Usage: !п <city>, or save your city with !set city <city>"})
+			return
+		}
+		params = append([]string{city}, params...)
 	}
 	city := strings.ToLower(params[0])
-	weather, err := getWeather(ctx, bot, city)
-	if err != nil {
-		bot.Logf("Weather for %q: %q", city, err)
+	if len(params) > 1 && strings.ToLower(params[1]) == "завтра" {
+		serveForecast(ctx, bot, msg, format, city, 1, 1)
 		return
 	}
-	msg.Reply(ctx, []string{weather.String()})
-}
-
-func getWeather(ctx context.Context, bot *ircbot, alias string) (result weather, err error) {
-	var (
-		city, country string
-	)
-	err = bot.stmts[fetchCity].QueryRowContext(ctx, alias).Scan(&city, &country)
+	weather, err := getWeather(ctx, bot, city, format.lang)
 	if err != nil {
+		replyCityError(ctx, bot, msg, city, err)
 		return
 	}
-	city = fmt.Sprintf("%s,%s", city, country)
-	bot.mu.Lock()
-	result, ok := bot.weatherCache[city]
-	bot.mu.Unlock()
-	if ok {
-		return
+	msg.Reply(ctx, []string{weather.format(format)})
+}
+
+// weatherFormat picks units preferred by the sender of msg,
+// falling back to the ones configured for the channel
+func (b *ircbot) weatherFormat(msg ircfw.Msg, prefs userPrefs) weatherFormat {
+	units := unitsMetric
+	if !msg.IsPrivate() {
+		if channelUnits, ok := b.config.weatherUnits[strings.ToLower(msg.Channel().Name())]; ok {
+			units = channelUnits
+		}
 	}
-	body, _, err := get(ctx, fmt.Sprintf(weatherURL, bot.config.weatherToken, city), "application/json", bot.config.userAgent)
-	if err != nil {
-		return
+	if userUnits, ok := prefs[prefUnits]; ok {
+		units = userUnits
 	}
-	defer body.Close()
-	b, err := ioutil.ReadAll(body)
-	if err != nil {
-		return
+	lang, ok := prefs[prefLang]
+	if !ok {
+		lang = defaultLang
 	}
-	err = json.Unmarshal(b, &result)
+	return weatherFormat{imperial: units == unitsImperial, lang: lang}
+}
+
+// getWeather asks weather providers in the configured order
+func getWeather(ctx context.Context, bot *ircbot, alias, lang string) (result weather, err error) {
+	loc, err := lookupCity(ctx, bot, alias, lang)
 	if err != nil {
 		return
 	}
-	bot.mu.Lock()
-	bot.weatherCache[city] = result
-	bot.mu.Unlock()
-	return
-}
-
-func (b *ircbot) pruneWeatherCache() error {
-	b.weatherCache = make(map[string]weather)
-	ticker := time.NewTicker(time.Minute)
-	for {
-		select {
-		case <-b.tomb.Dying():
-			ticker.Stop()
-			return tomb.ErrDying
-		case <-ticker.C:
+	for _, provider := range bot.weatherProviders {
+		provider := provider
+		key := provider.Name() + "|" + lang + "|" + loc.key()
+		result, err = bot.weatherCache.Get(ctx, key, func(ctx context.Context) (weather, error) {
+			return provider.Current(ctx, loc, lang)
+		})
+		if err == nil {
+			return
 		}
-		b.mu.Lock()
-		for city, weather := range b.weatherCache {
-			if weather.expired() {
-				delete(b.weatherCache, city)
-			}
+		bot.Logf("%s failed to get weather for %q: %q", provider.Name(), alias, err)
+		if ctx.Err() != nil {
+			break
 		}
-		b.mu.Unlock()
 	}
+	return
 }
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	feedAuto     = "auto"
+	feedTelegram = "telegram"
+	feedRSS      = "rss"
+	feedAtom     = "atom"
+
+	feedContentType = "application/rss+xml,application/atom+xml,application/xml,text/xml"
+
+	defaultFeedInterval = time.Hour
+)
+
+var (
+	feedTypes = []string{feedAuto, feedTelegram, feedRSS, feedAtom}
+
+	errNotModified = errors.New("feed not modified")
+)
+
+// newsItem is an entry of any feed type
+type newsItem struct {
+	// unique within the feed
+	ID          string
+	Title, Link string
+	Summary     string
+	Published   time.Time
+	HasDate     bool
+}
+
+// format renders the item as a single line, summaries are cut to
+// summaryLen runes and skipped if it is 0
+func (i newsItem) format(summaryLen int) string {
+	if i.Title == "" && i.Link == "" {
+		return i.Summary
+	}
+	parts := []string{i.Title}
+	if i.Link != "" {
+		parts = append(parts, i.Link)
+	}
+	if summaryLen > 0 && i.Summary != "" {
+		parts = append(parts, trimRunes(i.Summary, summaryLen))
+	}
+	return strings.Join(parts, " \x0310|\x03 ")
+}
+
+// feedState keeps validators of the last successful fetch
+type feedState struct {
+	etag, lastModified string
+}
+
+type rssFeed struct {
+	Channel struct {
+		Items []struct {
+			GUID        string `xml:"guid"`
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomFeed struct {
+	Entries []struct {
+		ID    string `xml:"id"`
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+	} `xml:"entry"`
+}
+
+// fetchNews returns items of the feed oldest first and stores validators
+// of the reply in state. Unchanged XML feeds result in errNotModified.
+func fetchNews(ctx context.Context, feed *feedConfig, state *feedState, userAgent string) ([]newsItem, error) {
+	if feed.kind == feedTelegram || (feed.kind == feedAuto && isTelegram(feed.url)) {
+		posts, err := getTGPosts(ctx, sharedClient, feed.url, userAgent)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]newsItem, 0, len(posts))
+		for _, post := range posts {
+			// edits change the text but not the post id
+			id := post.ID
+			if id == "" {
+				id = contentHash(post.Text)
+			}
+			items = append(items, newsItem{ID: id, Title: post.title(), Link: post.Link()})
+		}
+		return items, nil
+	}
+	header := make(http.Header)
+	if state.etag != "" {
+		header.Set("If-None-Match", state.etag)
+	}
+	if state.lastModified != "" {
+		header.Set("If-Modified-Since", state.lastModified)
+	}
+	response, _, err := getWith(ctx, feed.url, feedContentType, userAgent, header)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNotModified {
+		return nil, errNotModified
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(response.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	items, err := parseFeed(data, feed.kind)
+	if err != nil {
+		return nil, err
+	}
+	state.etag = response.Header.Get("ETag")
+	state.lastModified = response.Header.Get("Last-Modified")
+	return items, nil
+}
+
+func isTelegram(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && u.Hostname() == "t.me"
+}
+
+// parseFeed parses RSS 2.0 or Atom, kind feedAuto picks it by the root element
+func parseFeed(data []byte, kind string) (items []newsItem, err error) {
+	if kind == feedAuto {
+		if kind, err = feedRoot(data); err != nil {
+			return nil, err
+		}
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	switch kind {
+	case feedRSS:
+		var rss rssFeed
+		if err = decoder.Decode(&rss); err != nil {
+			return nil, err
+		}
+		for _, entry := range rss.Channel.Items {
+			item := newsItem{
+				ID:      firstNonEmpty(entry.GUID, entry.Link, contentHash(entry.Title, entry.Description)),
+				Title:   cleanText(entry.Title),
+				Link:    strings.TrimSpace(entry.Link),
+				Summary: cleanText(entry.Description),
+			}
+			item.Published, item.HasDate = parseFeedTime(entry.PubDate)
+			items = append(items, item)
+		}
+	case feedAtom:
+		var atom atomFeed
+		if err = decoder.Decode(&atom); err != nil {
+			return nil, err
+		}
+		for _, entry := range atom.Entries {
+			item := newsItem{
+				Title:   cleanText(entry.Title),
+				Summary: cleanText(firstNonEmpty(entry.Summary, entry.Content)),
+			}
+			for _, link := range entry.Links {
+				if link.Rel == "" || link.Rel == "alternate" {
+					item.Link = strings.TrimSpace(link.Href)
+					break
+				}
+			}
+			item.ID = firstNonEmpty(entry.ID, item.Link, contentHash(entry.Title, entry.Summary, entry.Content))
+			item.Published, item.HasDate = parseFeedTime(firstNonEmpty(entry.Published, entry.Updated))
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported feed type %q", kind)
+	}
+	sortNews(items)
+	return items, nil
+}
+
+// feedRoot tells RSS from Atom by the name of the root element
+func feedRoot(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return "", errors.New("no root element")
+		} else if err != nil {
+			return "", err
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "rss":
+			return feedRSS, nil
+		case "feed":
+			return feedAtom, nil
+		}
+		return "", fmt.Errorf("unsupported feed root %q", start.Name.Local)
+	}
+}
+
+// sortNews orders items oldest first. Feeds list the newest items first, so
+// without dates the document order is reversed.
+func sortNews(items []newsItem) {
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].HasDate || !items[j].HasDate {
+			return false
+		}
+		return items[i].Published.Before(items[j].Published)
+	})
+}
+
+var feedTimeLayouts = []string{
+	time.RFC1123Z, time.RFC1123, time.RFC3339,
+	"Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST",
+}
+
+func parseFeedTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// cleanText strips HTML markup and collapses whitespace
+func cleanText(value string) string {
+	var text strings.Builder
+	tokenizer := html.NewTokenizer(strings.NewReader(value))
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return strings.Join(strings.Fields(text.String()), " ")
+		case html.TextToken:
+			text.Write(tokenizer.Text())
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+			text.WriteByte(' ')
+		}
+	}
+}
+
+// trimRunes cuts value to at most n runes marking the cut with ellipsis
+func trimRunes(value string, n int) string {
+	if utf8.RuneCountInString(value) <= n {
+		return value
+	}
+	runes := []rune(value)
+	return strings.TrimSpace(string(runes[:n])) + "…"
+}
+
+// contentHash identifies items without ids
+func contentHash(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value = strings.TrimSpace(value); value != "" {
+			return value
+		}
+	}
+	return ""
+}
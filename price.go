@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	cryptoCompareURL = "https://min-api.cryptocompare.com"
+	coinGeckoURL     = "https://api.coingecko.com"
+	ecbURL           = "https://www.ecb.europa.eu"
+	xmlContentType   = "text/xml,application/xml"
+)
+
+var (
+	errUnknownSymbol = errors.New("unknown symbol")
+
+	priceProviders = map[string]func(userAgent string) priceProvider{
+		"cryptocompare": func(userAgent string) priceProvider {
+			return &cryptoCompare{baseURL: cryptoCompareURL, userAgent: userAgent}
+		},
+		"coingecko": func(userAgent string) priceProvider {
+			return &coinGecko{baseURL: coinGeckoURL, userAgent: userAgent}
+		},
+		"ecb": func(userAgent string) priceProvider {
+			return &ecb{baseURL: ecbURL, userAgent: userAgent}
+		},
+	}
+)
+
+// priceTicker is the state of a currency pair, 24h fields are zero
+// if the provider doesn't report them
+type priceTicker struct {
+	Price     float64
+	Change    float64 // 24h change in percents
+	HasChange bool
+	High, Low float64
+	// hourly prices for the last day, oldest first
+	History  []float64
+	Provider string
+}
+
+// priceProvider returns tickers of symbol in the requested quote currencies,
+// currencies unknown to the provider are omitted from the result.
+// errUnknownSymbol is returned if the provider doesn't know symbol at all.
+type priceProvider interface {
+	Name() string
+	Prices(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error)
+}
+
+// historyProvider is implemented by providers able to return hourly prices
+type historyProvider interface {
+	History(ctx context.Context, symbol, fiat string, hours int) ([]float64, error)
+}
+
+type cryptoCompare struct {
+	baseURL, userAgent string
+}
+
+// cryptoCompareError is the part of every cryptocompare reply
+// which is filled on errors
+type cryptoCompareError struct {
+	Response string `json:"Response"`
+	Message  string `json:"Message"`
+}
+
+func (e cryptoCompareError) err() error {
+	if e.Response != "Error" {
+		return nil
+	}
+	if strings.Contains(e.Message, "does not exist") {
+		return fmt.Errorf("%w: %s", errUnknownSymbol, e.Message)
+	}
+	return fmt.Errorf("price API error: %s", e.Message)
+}
+
+// priceResponse is the reply of cryptocompare pricemultifull endpoint,
+// tickers are keyed by symbol and then by quote currency
+type priceResponse struct {
+	cryptoCompareError
+	Raw map[string]map[string]struct {
+		Price     float64 `json:"PRICE"`
+		ChangePct float64 `json:"CHANGEPCT24HOUR"`
+		High      float64 `json:"HIGH24HOUR"`
+		Low       float64 `json:"LOW24HOUR"`
+	} `json:"RAW"`
+}
+
+type historyResponse struct {
+	cryptoCompareError
+	Data struct {
+		Data []struct {
+			Time  int64   `json:"time"`
+			Close float64 `json:"close"`
+		} `json:"Data"`
+	} `json:"Data"`
+}
+
+func (c *cryptoCompare) Name() string {
+	return "cryptocompare"
+}
+
+func (c *cryptoCompare) Prices(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error) {
+	query := url.Values{
+		"fsyms": {symbol},
+		"tsyms": {strings.Join(fiats, ",")},
+	}
+	body, _, err := get(ctx, c.baseURL+"/data/pricemultifull?"+query.Encode(), "application/json", c.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	return decodePrice(symbol, c.Name(), body)
+}
+
+func decodePrice(symbol, provider string, data io.Reader) (map[string]priceTicker, error) {
+	var response priceResponse
+	if err := json.NewDecoder(data).Decode(&response); err != nil {
+		return nil, err
+	}
+	if err := response.err(); err != nil {
+		return nil, err
+	}
+	raw, ok := response.Raw[symbol]
+	if !ok || len(raw) == 0 {
+		return nil, errUnknownSymbol
+	}
+	tickers := make(map[string]priceTicker, len(raw))
+	for fiat, t := range raw {
+		tickers[fiat] = priceTicker{
+			Price:     t.Price,
+			Change:    t.ChangePct,
+			HasChange: true,
+			High:      t.High,
+			Low:       t.Low,
+			Provider:  provider,
+		}
+	}
+	return tickers, nil
+}
+
+func (c *cryptoCompare) History(ctx context.Context, symbol, fiat string, hours int) ([]float64, error) {
+	query := url.Values{
+		"fsym":  {symbol},
+		"tsym":  {fiat},
+		"limit": {strconv.Itoa(hours)},
+	}
+	body, _, err := get(ctx, c.baseURL+"/data/v2/histohour?"+query.Encode(), "application/json", c.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var response historyResponse
+	if err = json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if err = response.err(); err != nil {
+		return nil, err
+	}
+	history := make([]float64, 0, len(response.Data.Data))
+	for _, point := range response.Data.Data {
+		history = append(history, point.Close)
+	}
+	return history, nil
+}
+
+// coinGecko addresses coins by ids like "bitcoin", ids of symbols are
+// resolved with the search endpoint and remembered
+type coinGecko struct {
+	baseURL, userAgent string
+	mu                 sync.Mutex
+	ids                map[string]string
+}
+
+func (c *coinGecko) Name() string {
+	return "coingecko"
+}
+
+func (c *coinGecko) coinID(ctx context.Context, symbol string) (string, error) {
+	c.mu.Lock()
+	id, ok := c.ids[symbol]
+	c.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+	var result struct {
+		Coins []struct {
+			ID     string `json:"id"`
+			Symbol string `json:"symbol"`
+		} `json:"coins"`
+	}
+	body, _, err := get(ctx, c.baseURL+"/api/v3/search?query="+url.QueryEscape(symbol), "application/json", c.userAgent)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	if err = json.NewDecoder(body).Decode(&result); err != nil {
+		return "", err
+	}
+	// coins are ordered by market cap, the first exact match is the one people mean
+	for _, coin := range result.Coins {
+		if strings.EqualFold(coin.Symbol, symbol) {
+			c.mu.Lock()
+			if c.ids == nil {
+				c.ids = make(map[string]string)
+			}
+			c.ids[symbol] = coin.ID
+			c.mu.Unlock()
+			return coin.ID, nil
+		}
+	}
+	return "", errUnknownSymbol
+}
+
+func (c *coinGecko) Prices(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error) {
+	id, err := c.coinID(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"ids":                 {id},
+		"vs_currencies":       {strings.ToLower(strings.Join(fiats, ","))},
+		"include_24hr_change": {"true"},
+	}
+	body, _, err := get(ctx, c.baseURL+"/api/v3/simple/price?"+query.Encode(), "application/json", c.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	// {"bitcoin": {"usd": 1, "usd_24h_change": 2}}
+	var response map[string]map[string]float64
+	if err = json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	values := response[id]
+	tickers := make(map[string]priceTicker, len(fiats))
+	for _, fiat := range fiats {
+		key := strings.ToLower(fiat)
+		price, ok := values[key]
+		if !ok {
+			continue
+		}
+		change, hasChange := values[key+"_24h_change"]
+		tickers[fiat] = priceTicker{Price: price, Change: change, HasChange: hasChange, Provider: c.Name()}
+	}
+	if len(tickers) == 0 {
+		return nil, errUnknownSymbol
+	}
+	return tickers, nil
+}
+
+func (c *coinGecko) History(ctx context.Context, symbol, fiat string, hours int) ([]float64, error) {
+	id, err := c.coinID(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{
+		"vs_currency": {strings.ToLower(fiat)},
+		"days":        {strconv.Itoa((hours + 23) / 24)},
+	}
+	body, _, err := get(ctx, c.baseURL+"/api/v3/coins/"+url.PathEscape(id)+"/market_chart?"+query.Encode(),
+		"application/json", c.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var response struct {
+		// [[unix milliseconds, price], ...]
+		Prices [][2]float64 `json:"prices"`
+	}
+	if err = json.NewDecoder(body).Decode(&response); err != nil {
+		return nil, err
+	}
+	// one day is reported in 5 minute steps, keep the last price of every hour
+	var (
+		history  []float64
+		lastHour int64 = -1
+	)
+	for _, point := range response.Prices {
+		hour := int64(point[0]) / 3600000
+		if hour == lastHour {
+			history[len(history)-1] = point[1]
+			continue
+		}
+		history = append(history, point[1])
+		lastHour = hour
+	}
+	if len(history) > hours {
+		history = history[len(history)-hours:]
+	}
+	return history, nil
+}
+
+// ecb converts between fiat currencies using the daily reference rates
+// of the European Central Bank, which are quoted against EUR
+type ecb struct {
+	baseURL, userAgent string
+}
+
+type ecbEnvelope struct {
+	Rates []struct {
+		Currency string `xml:"currency,attr"`
+		Rate     string `xml:"rate,attr"`
+	} `xml:"Cube>Cube>Cube"`
+}
+
+func (e *ecb) Name() string {
+	return "ECB"
+}
+
+func (e *ecb) rates(ctx context.Context) (map[string]float64, error) {
+	body, _, err := get(ctx, e.baseURL+"/stats/eurofxref/eurofxref-daily.xml", xmlContentType, e.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	var envelope ecbEnvelope
+	if err = xml.NewDecoder(body).Decode(&envelope); err != nil {
+		return nil, err
+	}
+	rates := map[string]float64{"EUR": 1}
+	for _, rate := range envelope.Rates {
+		value, err := strconv.ParseFloat(rate.Rate, 64)
+		if err != nil || value <= 0 {
+			continue
+		}
+		rates[rate.Currency] = value
+	}
+	if len(rates) == 1 {
+		return nil, errors.New("no rates in ECB reply")
+	}
+	return rates, nil
+}
+
+func (e *ecb) Prices(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error) {
+	rates, err := e.rates(ctx)
+	if err != nil {
+		return nil, err
+	}
+	base, ok := rates[symbol]
+	if !ok {
+		return nil, errUnknownSymbol
+	}
+	tickers := make(map[string]priceTicker, len(fiats))
+	for _, fiat := range fiats {
+		if rate, ok := rates[fiat]; ok {
+			tickers[fiat] = priceTicker{Price: rate / base, Provider: e.Name()}
+		}
+	}
+	return tickers, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testLogger struct {
+	t *testing.T
+}
+
+func (l testLogger) Log(v ...interface{})                  { l.t.Log(v...) }
+func (l testLogger) Logf(format string, v ...interface{})  { l.t.Logf(format, v...) }
+func (l testLogger) Debug(format string, v ...interface{}) { l.t.Logf(format, v...) }
+
+// serveJSON answers requests to the route paths with their JSON bodies
+func serveJSON(t *testing.T, routes map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCryptoCompare(t *testing.T) {
+	server := serveJSON(t, map[string]string{
+		"/data/pricemultifull": `{"RAW": {"BTC": {
+			"USD": {"PRICE": 50000, "CHANGEPCT24HOUR": -1.5, "HIGH24HOUR": 51000, "LOW24HOUR": 49000},
+			"EUR": {"PRICE": 45000, "CHANGEPCT24HOUR": 2, "HIGH24HOUR": 46000, "LOW24HOUR": 44000}}}}`,
+	})
+	provider := &cryptoCompare{baseURL: server.URL}
+	tickers, err := provider.Prices(context.Background(), "BTC", []string{"USD", "EUR"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := priceTicker{Price: 50000, Change: -1.5, HasChange: true, High: 51000, Low: 49000, Provider: "cryptocompare"}
+	if got := tickers["USD"]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("USD ticker is %+v, want %+v", got, want)
+	}
+	if got := tickers["EUR"].Price; got != 45000 {
+		t.Errorf("EUR price is %v, want 45000", got)
+	}
+}
+
+func TestCryptoCompareErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name, body string
+		unknown    bool
+	}{
+		{"unknown symbol", `{"Response": "Error", "Message": "fsyms param does not exist"}`, true},
+		{"missing symbol", `{"RAW": {}}`, true},
+		{"rate limit", `{"Response": "Error", "Message": "rate limit"}`, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server := serveJSON(t, map[string]string{"/data/pricemultifull": tt.body})
+			provider := &cryptoCompare{baseURL: server.URL}
+			_, err := provider.Prices(context.Background(), "XYZ", []string{"USD"})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if got := errors.Is(err, errUnknownSymbol); got != tt.unknown {
+				t.Errorf("error %q is unknown symbol: %v, want %v", err, got, tt.unknown)
+			}
+		})
+	}
+}
+
+func TestCoinGecko(t *testing.T) {
+	server := serveJSON(t, map[string]string{
+		"/api/v3/search": `{"coins": [
+			{"id": "wrapped-ether", "symbol": "WETH"},
+			{"id": "ethereum", "symbol": "ETH"},
+			{"id": "ethereum-classic", "symbol": "ETH"}]}`,
+		"/api/v3/simple/price": `{"ethereum": {"usd": 3000, "usd_24h_change": 4.2, "eur": 2700}}`,
+	})
+	provider := &coinGecko{baseURL: server.URL}
+	tickers, err := provider.Prices(context.Background(), "ETH", []string{"USD", "EUR", "XAU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := provider.ids["ETH"]; id != "ethereum" {
+		t.Errorf("ETH resolved to %q, want the first exact match", id)
+	}
+	if got := tickers["USD"]; got.Price != 3000 || got.Change != 4.2 || !got.HasChange {
+		t.Errorf("USD ticker is %+v", got)
+	}
+	if got := tickers["EUR"]; got.Price != 2700 || got.HasChange {
+		t.Errorf("EUR ticker is %+v, want price without change", got)
+	}
+	if _, ok := tickers["XAU"]; ok {
+		t.Error("currency missing from the reply is reported")
+	}
+	if _, err = provider.Prices(context.Background(), "NOPE", []string{"USD"}); !errors.Is(err, errUnknownSymbol) {
+		t.Errorf("unknown coin error is %v, want errUnknownSymbol", err)
+	}
+}
+
+const ecbReply = `<?xml version="1.0" encoding="UTF-8"?>
+<gesmes:Envelope xmlns:gesmes="http://www.gesmes.org/xml/2002-08-01" xmlns="http://www.ecb.int/vocabulary/2002-08-01/eurofxref">
+	<gesmes:subject>Reference rates</gesmes:subject>
+	<Cube>
+		<Cube time="2026-10-16">
+			<Cube currency="USD" rate="1.10"/>
+			<Cube currency="JPY" rate="165.00"/>
+			<Cube currency="BAD" rate="n/a"/>
+		</Cube>
+	</Cube>
+</gesmes:Envelope>`
+
+func serveECB(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats/eurofxref/eurofxref-daily.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, ecbReply)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestECB(t *testing.T) {
+	provider := &ecb{baseURL: serveECB(t).URL}
+	tickers, err := provider.Prices(context.Background(), "USD", []string{"EUR", "JPY", "BAD", "GBP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tickers) != 2 {
+		t.Errorf("got %d tickers, want EUR and JPY only: %+v", len(tickers), tickers)
+	}
+	if got, want := tickers["EUR"].Price, 1/1.10; got != want {
+		t.Errorf("USD/EUR is %v, want %v", got, want)
+	}
+	if got, want := tickers["JPY"].Price, 165/1.10; got != want {
+		t.Errorf("USD/JPY is %v, want %v", got, want)
+	}
+	if _, err = provider.Prices(context.Background(), "BTC", []string{"EUR"}); !errors.Is(err, errUnknownSymbol) {
+		t.Errorf("unknown base error is %v, want errUnknownSymbol", err)
+	}
+	// known base in unknown currencies is no error, getPrice treats it as unknown
+	tickers, err = provider.Prices(context.Background(), "EUR", []string{"GBP"})
+	if err != nil || len(tickers) != 0 {
+		t.Errorf("EUR/GBP returned %+v, %v, want empty map", tickers, err)
+	}
+}
+
+// fakeProvider replies with fixed tickers or error and counts calls
+type fakeProvider struct {
+	name    string
+	tickers map[string]priceTicker
+	err     error
+	calls   int
+}
+
+func (p *fakeProvider) Name() string {
+	return p.name
+}
+
+func (p *fakeProvider) Prices(ctx context.Context, symbol string, fiats []string) (map[string]priceTicker, error) {
+	p.calls++
+	return p.tickers, p.err
+}
+
+func TestGetPrice(t *testing.T) {
+	var (
+		down    = errors.New("connection refused")
+		emptyEU = &ecb{baseURL: serveECB(t).URL}
+		found   = map[string]priceTicker{"USD": {Price: 1, Provider: "found"}}
+	)
+	for _, tt := range []struct {
+		name      string
+		providers []priceProvider
+		fiats     []string
+		provider  string
+		err       error
+	}{
+		{
+			name: "first answering",
+			providers: []priceProvider{
+				&fakeProvider{name: "down", err: down},
+				&fakeProvider{name: "unknown", err: errUnknownSymbol},
+				&fakeProvider{name: "found", tickers: found},
+				&fakeProvider{name: "unused", err: errors.New("asked after success")},
+			},
+			fiats:    []string{"USD"},
+			provider: "found",
+		},
+		{
+			name: "unknown everywhere",
+			providers: []priceProvider{
+				&fakeProvider{name: "unknown", err: fmt.Errorf("%w: no such coin", errUnknownSymbol)},
+				emptyEU,
+			},
+			fiats: []string{"GBP"},
+			err:   errUnknownSymbol,
+		},
+		{
+			name: "unknown and down",
+			providers: []priceProvider{
+				&fakeProvider{name: "unknown", err: errUnknownSymbol},
+				&fakeProvider{name: "down", err: down},
+				emptyEU,
+			},
+			fiats: []string{"GBP"},
+			err:   down,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &ircbot{priceProviders: tt.providers, logger: testLogger{t}}
+			tickers, err := bot.getPrice(context.Background(), "EUR", tt.fiats)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error is %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if got := tickers["USD"].Provider; got != tt.provider {
+				t.Errorf("price from %q, want %q", got, tt.provider)
+			}
+			for _, p := range tt.providers {
+				if fake, ok := p.(*fakeProvider); ok && fake.name == "unused" && fake.calls != 0 {
+					t.Error("provider after the answering one was asked")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	tgHost = "t.me"
+	// post text in news lines and link titles is cut to that length
+	tgTextLen = 300
+)
+
+// tgMedia maps classes of attachment containers to media hints
+var tgMedia = []struct {
+	class, hint string
+}{
+	{"tgme_widget_message_photo_wrap", "photo"},
+	{"tgme_widget_message_video_player", "video"},
+	{"tgme_widget_message_roundvideo_player", "video"},
+	{"tgme_widget_message_voice_player", "voice"},
+	{"tgme_widget_message_document_wrap", "file"},
+	{"tgme_widget_message_poll", "poll"},
+}
+
+// tgPost is a message of the channel web preview
+type tgPost struct {
+	// channel/number, empty if the page doesn't tell it
+	ID    string
+	Text  string
+	Media []string
+	// as shown by Telegram, like 12.3K
+	Views string
+}
+
+// Link returns the permalink of the post
+func (p tgPost) Link() string {
+	if p.ID == "" {
+		return ""
+	}
+	return "https://" + tgHost + "/" + p.ID
+}
+
+// number returns the number of the post in the channel or 0 if unknown
+func (p tgPost) number() int {
+	i := strings.LastIndex(p.ID, "/")
+	if i == -1 {
+		return 0
+	}
+	n, _ := strconv.Atoi(p.ID[i+1:])
+	return n
+}
+
+// title is the post text prefixed with media hints
+func (p tgPost) title() string {
+	var hints string
+	for _, media := range p.Media {
+		hints += "[" + media + "] "
+	}
+	return strings.TrimSpace(hints + trimRunes(p.Text, tgTextLen))
+}
+
+// String renders the post for link titles
+func (p tgPost) String() string {
+	if p.Views == "" {
+		return p.title()
+	}
+	return fmt.Sprintf("%s \x0310|\x03 \x0312V:\x03 %s", p.title(), p.Views)
+}
+
+func hasClass(n *html.Node, class string) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, attr := range n.Attr {
+		if attr.Key == "class" {
+			for _, value := range strings.Fields(attr.Val) {
+				if value == class {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func byClass(class string) filterFunc {
+	return func(n *html.Node) bool {
+		return hasClass(n, class)
+	}
+}
+
+func isTGPost(n *html.Node) bool {
+	if n.Type == html.ElementNode && n.Data == "div" {
+		for _, attr := range n.Attr {
+			if attr.Key == "data-post" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// nodeText flattens text of n and its descendants, line breaks become spaces
+func nodeText(n *html.Node) string {
+	var text strings.Builder
+	var walk func(n *html.Node, depth uint)
+	walk = func(n *html.Node, depth uint) {
+		if depth == RecursionLimit {
+			return
+		}
+		switch {
+		case n.Type == html.TextNode:
+			text.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			text.WriteByte(' ')
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child, depth+1)
+		}
+	}
+	walk(n, 0)
+	return strings.Join(strings.Fields(text.String()), " ")
+}
+
+func parseTGPost(node *html.Node) tgPost {
+	var post tgPost
+	for _, attr := range node.Attr {
+		if attr.Key == "data-post" {
+			post.ID = attr.Val
+		}
+	}
+	if text := collect(node, 0, byClass("tgme_widget_message_text"), nil); len(text) != 0 {
+		// the last one, forwarded and replied posts quote others before it
+		post.Text = nodeText(text[len(text)-1])
+	}
+	for _, media := range tgMedia {
+		if len(collect(node, 0, byClass(media.class), nil)) != 0 && !contains(post.Media, media.hint) {
+			post.Media = append(post.Media, media.hint)
+		}
+	}
+	if views := collect(node, 0, byClass("tgme_widget_message_views"), nil); len(views) != 0 {
+		post.Views = nodeText(views[0])
+	}
+	return post
+}
+
+// extractTGPosts returns posts of the channel preview or embedded post page
+// ordered by their numbers
+func extractTGPosts(data io.Reader) (posts []tgPost, err error) {
+	tree, err := html.Parse(data)
+	if err != nil {
+		return
+	}
+	for _, node := range collect(tree, 0, isTGPost, nil) {
+		post := parseTGPost(node)
+		if post.Text == "" && len(post.Media) == 0 {
+			continue
+		}
+		posts = append(posts, post)
+	}
+	if len(posts) == 0 {
+		return nil, errors.New("failed to extract post")
+	}
+	sort.SliceStable(posts, func(i, j int) bool {
+		return posts[i].number() < posts[j].number()
+	})
+	return
+}
+
+// tgPostURL returns the embeddable page of t.me/<channel>/<id> links
+func tgPostURL(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Hostname() != tgHost && u.Hostname() != "telegram.me") {
+		return "", false
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) == 3 && parts[0] == "s" {
+		parts = parts[1:]
+	}
+	if len(parts) != 2 || parts[0] == "" {
+		return "", false
+	}
+	if _, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return "", false
+	}
+	return fmt.Sprintf("https://%s/%s/%s?embed=1", tgHost, parts[0], parts[1]), true
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+)
+
+const (
+	// runes of post and comment text in titles
+	siteTextLen = 300
+)
+
+var (
+	// extractor names for TitleSites
+	siteExtractors = map[string]siteExtractor{
+		"youtube":    youtubeSite,
+		"telegram":   telegramSite,
+		"github":     githubSite,
+		"gitea":      giteaSite,
+		"wikipedia":  wikipediaSite,
+		"reddit":     redditSite,
+		"mastodon":   mastodonSite,
+		"hackernews": hackerNewsSite,
+	}
+	// host patterns to extractor names, *.example.com matches subdomains only
+	titleSites = map[string]string{
+		"youtube.com":          "youtube",
+		"*.youtube.com":        "youtube",
+		"youtu.be":             "youtube",
+		"t.me":                 "telegram",
+		"telegram.me":          "telegram",
+		"github.com":           "github",
+		"www.github.com":       "github",
+		"gitea.com":            "gitea",
+		"codeberg.org":         "gitea",
+		"*.wikipedia.org":      "wikipedia",
+		"reddit.com":           "reddit",
+		"*.reddit.com":         "reddit",
+		"redd.it":              "reddit",
+		"mastodon.social":      "mastodon",
+		"mastodon.online":      "mastodon",
+		"mas.to":               "mastodon",
+		"fosstodon.org":        "mastodon",
+		"infosec.exchange":     "mastodon",
+		"news.ycombinator.com": "hackernews",
+	}
+
+	// the link is left to the generic title extraction
+	errUnsupportedLink = errors.New("link is not supported by the site extractor")
+)
+
+// titleFetch is the context of getting a title of one link
+type titleFetch struct {
+	client    *httpClient
+	userAgent string
+	rich      bool
+	// host patterns to extractor names
+	sites map[string]string
+}
+
+// siteExtractor makes the title of a link to a known site, it returns
+// errUnsupportedLink for pages of the site it doesn't know
+type siteExtractor func(ctx context.Context, f titleFetch, link *url.URL) (string, error)
+
+// newTitleSites adds TitleSites entries to the built-in ones
+func newTitleSites(extra map[string]string) map[string]string {
+	sites := make(map[string]string, len(titleSites)+len(extra))
+	for pattern, name := range titleSites {
+		sites[pattern] = name
+	}
+	for pattern, name := range extra {
+		sites[pattern] = name
+	}
+	return sites
+}
+
+// lookupSite finds the extractor by the host, then by wildcard patterns
+// of its parent domains
+func (f titleFetch) lookupSite(host string) (siteExtractor, bool) {
+	host = strings.ToLower(host)
+	name, ok := f.sites[host]
+	for domain := host; !ok; {
+		i := strings.Index(domain, ".")
+		if i < 0 {
+			return nil, false
+		}
+		domain = domain[i+1:]
+		name, ok = f.sites["*."+domain]
+	}
+	extractor, ok := siteExtractors[name]
+	return extractor, ok
+}
+
+func (f titleFetch) page(ctx context.Context, link string) (*html.Node, error) {
+	body, utf8, err := f.client.get(ctx, link, "text/html", f.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parsePage(body, utf8)
+}
+
+func (f titleFetch) json(ctx context.Context, link string, result interface{}) error {
+	body, _, err := f.client.get(ctx, link, "application/json", f.userAgent)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(result)
+}
+
+// pathParts splits the path dropping empty parts
+func pathParts(link *url.URL) []string {
+	return strings.FieldsFunc(link.Path, func(r rune) bool { return r == '/' })
+}
+
+func joinTitle(parts ...string) string {
+	var nonEmpty []string
+	for _, part := range parts {
+		if part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, " \x0310|\x03 ")
+}
+
+func youtubeSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	tree, err := f.page(ctx, link.String())
+	if err != nil {
+		return "", err
+	}
+	fallback, err := pageTitle(tree, f.rich)
+	if err != nil {
+		return "", err
+	}
+	return extractYoutube(tree, fallback), nil
+}
+
+func telegramSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	embed, ok := tgPostURL(link.String())
+	if !ok {
+		return "", errUnsupportedLink
+	}
+	return getTGTitle(ctx, f, embed)
+}
+
+// forgeRepo and forgeIssue are common for GitHub and Gitea APIs
+type forgeRepo struct {
+	FullName    string `json:"full_name"`
+	Description string `json:"description"`
+	// GitHub and Gitea respectively
+	Stargazers int `json:"stargazers_count"`
+	Stars      int `json:"stars_count"`
+}
+
+type forgeIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	State  string `json:"state"`
+	User   struct {
+		Login string `json:"login"`
+	} `json:"user"`
+	PullRequest *struct{} `json:"pull_request"`
+}
+
+func (r forgeRepo) String() string {
+	return joinTitle(r.FullName, r.Description, fmt.Sprintf("★ %d", r.Stargazers+r.Stars))
+}
+
+func (i forgeIssue) format(repo string) string {
+	kind := "issue"
+	if i.PullRequest != nil {
+		kind = "PR"
+	}
+	return joinTitle(i.Title, fmt.Sprintf("%s %s#%d", kind, repo, i.Number), fmt.Sprintf("%s by %s", i.State, i.User.Login))
+}
+
+// forgeTitle serves owner/repo and owner/repo/<issues>/N links, issueKinds
+// name the path segments of issues and pull requests
+func forgeTitle(ctx context.Context, f titleFetch, link *url.URL, apiBase string, issueKinds ...string) (string, error) {
+	parts := pathParts(link)
+	switch {
+	case len(parts) == 2:
+		var repo forgeRepo
+		if err := f.json(ctx, fmt.Sprintf("%s/repos/%s/%s", apiBase, parts[0], parts[1]), &repo); err != nil {
+			return "", err
+		}
+		return repo.String(), nil
+	case len(parts) >= 4 && contains(issueKinds, parts[2]):
+		if _, err := strconv.ParseUint(parts[3], 10, 64); err != nil {
+			return "", errUnsupportedLink
+		}
+		var issue forgeIssue
+		if err := f.json(ctx, fmt.Sprintf("%s/repos/%s/%s/issues/%s", apiBase, parts[0], parts[1], parts[3]), &issue); err != nil {
+			return "", err
+		}
+		return issue.format(parts[0] + "/" + parts[1]), nil
+	}
+	return "", errUnsupportedLink
+}
+
+func githubSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	return forgeTitle(ctx, f, link, "https://api.github.com", "issues", "pull")
+}
+
+func giteaSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	return forgeTitle(ctx, f, link, link.Scheme+"://"+link.Host+"/api/v1", "issues", "pulls")
+}
+
+// wikipediaSite shows the first sentence of the article
+func wikipediaSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	article := strings.TrimPrefix(link.EscapedPath(), "/wiki/")
+	if article == link.EscapedPath() || article == "" || strings.Contains(article, ":") {
+		return "", errUnsupportedLink
+	}
+	host := strings.Replace(link.Host, ".m.wikipedia.org", ".wikipedia.org", 1)
+	var summary struct {
+		Title   string `json:"title"`
+		Extract string `json:"extract"`
+	}
+	if err := f.json(ctx, "https://"+host+"/api/rest_v1/page/summary/"+article, &summary); err != nil {
+		return "", err
+	}
+	return joinTitle(summary.Title, trimRunes(firstSentence(summary.Extract), siteTextLen)), nil
+}
+
+// firstSentence cuts text after the first sentence terminator followed by space
+func firstSentence(text string) string {
+	text = strings.TrimSpace(text)
+	for i, r := range text {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		next := i + utf8.RuneLen(r)
+		if next == len(text) || text[next] == ' ' || text[next] == '\n' {
+			return text[:next]
+		}
+	}
+	return text
+}
+
+func redditSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	parts := pathParts(link)
+	var id string
+	switch {
+	case strings.ToLower(link.Hostname()) == "redd.it" && len(parts) == 1:
+		id = parts[0]
+	case len(parts) >= 4 && parts[0] == "r" && parts[2] == "comments":
+		id = parts[3]
+	default:
+		return "", errUnsupportedLink
+	}
+	var listings []struct {
+		Data struct {
+			Children []struct {
+				Data struct {
+					Title     string `json:"title"`
+					Subreddit string `json:"subreddit_name_prefixed"`
+					Author    string `json:"author"`
+					Score     int    `json:"score"`
+					Comments  int    `json:"num_comments"`
+				} `json:"data"`
+			} `json:"children"`
+		} `json:"data"`
+	}
+	if err := f.json(ctx, "https://www.reddit.com/comments/"+url.PathEscape(id)+".json", &listings); err != nil {
+		return "", err
+	}
+	if len(listings) == 0 || len(listings[0].Data.Children) == 0 {
+		return "", errors.New("no reddit post in the reply")
+	}
+	post := listings[0].Data.Children[0].Data
+	return joinTitle(post.Title, post.Subreddit,
+		fmt.Sprintf("%d points by u/%s", post.Score, post.Author), fmt.Sprintf("%d comments", post.Comments)), nil
+}
+
+// mastodonSite serves /@user/id and /users/user/statuses/id links
+func mastodonSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	parts := pathParts(link)
+	if len(parts) < 2 || (!strings.HasPrefix(parts[0], "@") && parts[0] != "users") {
+		return "", errUnsupportedLink
+	}
+	id := parts[len(parts)-1]
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return "", errUnsupportedLink
+	}
+	var status struct {
+		Content string `json:"content"`
+		Account struct {
+			Acct        string `json:"acct"`
+			DisplayName string `json:"display_name"`
+		} `json:"account"`
+		Reblogs    int `json:"reblogs_count"`
+		Favourites int `json:"favourites_count"`
+	}
+	if err := f.json(ctx, link.Scheme+"://"+link.Host+"/api/v1/statuses/"+id, &status); err != nil {
+		return "", err
+	}
+	author := "@" + status.Account.Acct
+	if name := strings.TrimSpace(status.Account.DisplayName); name != "" {
+		author = fmt.Sprintf("%s (%s)", name, author)
+	}
+	return joinTitle(fmt.Sprintf("%s: %s", author, trimRunes(cleanText(status.Content), siteTextLen)),
+		fmt.Sprintf("↻ %d ★ %d", status.Reblogs, status.Favourites)), nil
+}
+
+func hackerNewsSite(ctx context.Context, f titleFetch, link *url.URL) (string, error) {
+	id := link.Query().Get("id")
+	if link.Path != "/item" || id == "" {
+		return "", errUnsupportedLink
+	}
+	if _, err := strconv.ParseUint(id, 10, 64); err != nil {
+		return "", errUnsupportedLink
+	}
+	var item struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Text     string `json:"text"`
+		By       string `json:"by"`
+		Score    int    `json:"score"`
+		Comments int    `json:"descendants"`
+	}
+	if err := f.json(ctx, "https://hacker-news.firebaseio.com/v0/item/"+id+".json", &item); err != nil {
+		return "", err
+	}
+	if item.By == "" {
+		return "", fmt.Errorf("no hacker news item %s", id)
+	}
+	if item.Type == "comment" {
+		return fmt.Sprintf("%s: %s", item.By, trimRunes(cleanText(item.Text), siteTextLen)), nil
+	}
+	return joinTitle(item.Title, fmt.Sprintf("%d points by %s", item.Score, item.By),
+		fmt.Sprintf("%d comments", item.Comments)), nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"strings"
+	"time"
 )
 
 // result shares slice with lines parameter
@@ -45,3 +46,42 @@ func splitTrim(line string, separator string) (result []string) {
 	}
 	return
 }
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+func firstOr(list []string, fallback string) string {
+	if len(list) == 0 {
+		return fallback
+	}
+	return list[0]
+}
+
+// parseClock turns 15:04 into the offset from midnight
+func parseClock(value string) (time.Duration, error) {
+	at, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute, nil
+}
+
+// onDay returns the wall clock time at, as given by parseClock, on the day
+// of t shifted by days. Unlike adding at to midnight it isn't off by an hour
+// on days when DST starts or ends.
+func onDay(t time.Time, days int, at time.Duration) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+days, int(at/time.Hour), int(at%time.Hour/time.Minute),
+		0, 0, t.Location())
+}
+
+// sinceMidnight returns the offset of t from the local midnight
+func sinceMidnight(t time.Time) time.Duration {
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+}
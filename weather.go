@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	mmHgMagic = 0.750062
+	inHgMagic = 0.02953
+	mphMagic  = 2.23694
+
+	langRu        = "ru"
+	langEn        = "en"
+	defaultLang   = langRu
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+)
+
+var (
+	weatherProviders = map[string]func(token, userAgent string) weatherProvider{
+		"openweathermap": func(token, userAgent string) weatherProvider {
+			return &openWeatherMap{baseURL: openWeatherMapURL, token: token, userAgent: userAgent}
+		},
+		"openmeteo": func(token, userAgent string) weatherProvider {
+			return &openMeteo{
+				baseURL:    openMeteoURL,
+				geocodeURL: openMeteoGeocodeURL,
+				userAgent:  userAgent,
+			}
+		},
+	}
+)
+
+// weatherProvider is a source of current weather, forecasts and geocoding.
+// Values are metric: °C, hPa and m/s.
+type weatherProvider interface {
+	// Name is the key of the provider in weatherProviders and WeatherProviders
+	Name() string
+	// Current describes the weather in lang
+	Current(ctx context.Context, loc location, lang string) (weather, error)
+	// Forecast returns days starting from today in the local time of the place
+	Forecast(ctx context.Context, loc location, days int, lang string) (forecast, error)
+	// Geocode names places in lang where the provider knows the name
+	Geocode(ctx context.Context, name, lang string) ([]geoPlace, error)
+}
+
+// location is a place weather is asked for, either a city,country pair from
+// the aliases table or geocoded coordinates
+type location struct {
+	City, Country string
+	Lat, Lon      float64
+	HasCoords     bool
+}
+
+func (l location) key() string {
+	if l.HasCoords {
+		return strconv.FormatFloat(l.Lat, 'f', 4, 64) + "," + strconv.FormatFloat(l.Lon, 'f', 4, 64)
+	}
+	return strings.ToLower(l.City + "," + l.Country)
+}
+
+type geoPlace struct {
+	Name, State, Country string
+	Lat, Lon             float64
+}
+
+func (p geoPlace) String() string {
+	if p.State != "" {
+		return fmt.Sprintf("%s, %s, %s", p.Name, p.State, p.Country)
+	}
+	return fmt.Sprintf("%s, %s", p.Name, p.Country)
+}
+
+func (p geoPlace) location() location {
+	return location{City: p.Name, Country: p.Country, Lat: p.Lat, Lon: p.Lon, HasCoords: true}
+}
+
+// weather is the current weather independent of the provider
+type weather struct {
+	Place, Country   string
+	Description      string
+	Temp, FeelsLike  float64
+	Pressure         float64
+	Humidity         int
+	WindSpeed        float64
+	WindDeg          float64
+	TempMin, TempMax float64
+	// in the local time of the place, zero if unknown
+	Sunrise, Sunset time.Time
+	Provider        string
+}
+
+func (w weather) format(f weatherFormat) string {
+	l := f.labels()
+	wind := f.speed(w.WindSpeed)
+	// no direction in calm weather
+	if w.WindSpeed > 0 {
+		wind = l.compass(w.WindDeg) + " " + wind
+	}
+	parts := []string{
+		fmt.Sprintf("%s/%s: %s", w.Place, w.Country, w.Description),
+		fmt.Sprintf("%s: %.1f %s, %s %.1f", l.temp, f.degrees(w.Temp), f.unit(), l.feelsLike, f.degrees(w.FeelsLike)),
+	}
+	if w.TempMin != w.TempMax {
+		parts = append(parts, fmt.Sprintf("%s: %.0f..%.0f", l.minMax, f.degrees(w.TempMin), f.degrees(w.TempMax)))
+	}
+	parts = append(parts,
+		fmt.Sprintf("%s: %s", l.pressure, f.pressure(w.Pressure)),
+		fmt.Sprintf("%s: %s", l.wind, wind),
+		fmt.Sprintf("%s: %d%%", l.humidity, w.Humidity),
+	)
+	if !w.Sunrise.IsZero() && !w.Sunset.IsZero() {
+		parts = append(parts, fmt.Sprintf("%s %s, %s %s",
+			l.sunrise, w.Sunrise.Format("15:04"), l.sunset, w.Sunset.Format("15:04")))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// forecast is the daily forecast independent of the provider
+type forecast struct {
+	Place, Country string
+	Days           []forecastDay
+	Provider       string
+}
+
+type forecastDay struct {
+	Date      time.Time
+	Min, Max  float64
+	Condition string
+	// probability of precipitation, 0..1
+	Pop  float64
+	Wind float64
+}
+
+func (d forecastDay) format(f weatherFormat) string {
+	l := f.labels()
+	return fmt.Sprintf("%s %s: %.0f..%.0f %s, %s, %s %.0f%%, %s %s",
+		l.weekdays[d.Date.Weekday()], d.Date.Format("02.01"), f.degrees(d.Min), f.degrees(d.Max), f.unit(),
+		d.Condition, l.precipitation, d.Pop*100, l.windUpTo, f.speed(d.Wind))
+}
+
+// weatherFormat is the unit system and the language weather is shown in
+type weatherFormat struct {
+	imperial bool
+	lang     string
+}
+
+// weatherLabels are words of weather replies in one language
+type weatherLabels struct {
+	temp, feelsLike, minMax, pressure string
+	wind, humidity, sunrise, sunset   string
+	precipitation, windUpTo           string
+	mmHg, hPa, inHg, ms, mph          string
+	weekdays                          [7]string
+	points                            [16]string
+}
+
+var weatherLangs = map[string]weatherLabels{
+	langRu: {
+		temp: "температура", feelsLike: "ощущается как", minMax: "мин/макс",
+		pressure: "давление", wind: "ветер", humidity: "относ. влаж.",
+		sunrise: "восход", sunset: "закат",
+		precipitation: "осадки", windUpTo: "ветер до",
+		mmHg: "мм рт.ст", hPa: "гПа", inHg: "дюйм рт.ст", ms: "м/с", mph: "миль/ч",
+		weekdays: [7]string{"вс", "пн", "вт", "ср", "чт", "пт", "сб"},
+		points: [16]string{"С", "ССВ", "СВ", "ВСВ", "В", "ВЮВ", "ЮВ", "ЮЮВ",
+			"Ю", "ЮЮЗ", "ЮЗ", "ЗЮЗ", "З", "ЗСЗ", "СЗ", "ССЗ"},
+	},
+	langEn: {
+		temp: "temperature", feelsLike: "feels like", minMax: "min/max",
+		pressure: "pressure", wind: "wind", humidity: "humidity",
+		sunrise: "sunrise", sunset: "sunset",
+		precipitation: "precipitation", windUpTo: "wind up to",
+		mmHg: "mmHg", hPa: "hPa", inHg: "inHg", ms: "m/s", mph: "mph",
+		weekdays: [7]string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		points: [16]string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE",
+			"S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"},
+	},
+}
+
+func (f weatherFormat) labels() weatherLabels {
+	if l, ok := weatherLangs[f.lang]; ok {
+		return l
+	}
+	return weatherLangs[defaultLang]
+}
+
+// compass turns the direction wind blows from into one of 16 points
+func (l weatherLabels) compass(deg float64) string {
+	i := int(math.Mod(deg+11.25, 360) / 22.5)
+	if i < 0 {
+		i += len(l.points)
+	}
+	return l.points[i%len(l.points)]
+}
+
+// degrees converts °C to the unit system
+func (f weatherFormat) degrees(celsius float64) float64 {
+	if f.imperial {
+		return celsius*9/5 + 32
+	}
+	return celsius
+}
+
+func (f weatherFormat) unit() string {
+	if f.imperial {
+		return "°F"
+	}
+	return "°C"
+}
+
+func (f weatherFormat) speed(ms float64) string {
+	l := f.labels()
+	if f.imperial {
+		return fmt.Sprintf("%.1f %s", ms*mphMagic, l.mph)
+	}
+	return fmt.Sprintf("%.1f %s", ms, l.ms)
+}
+
+// pressure shows hPa as mmHg in Russian and as is otherwise
+func (f weatherFormat) pressure(hPa float64) string {
+	l := f.labels()
+	switch {
+	case f.imperial:
+		return fmt.Sprintf("%.2f %s", hPa*inHgMagic, l.inHg)
+	case f.lang == langRu:
+		return fmt.Sprintf("%.1f %s", hPa*mmHgMagic, l.mmHg)
+	}
+	return fmt.Sprintf("%.0f %s", hPa, l.hPa)
+}
+
+// dedupPlaces drops places listed several times with close coordinates
+func dedupPlaces(found []geoPlace) (places []geoPlace) {
+	seen := make(map[string]bool)
+	for _, place := range found {
+		key := place.Name + "|" + place.State + "|" + place.Country
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		places = append(places, place)
+	}
+	return
+}
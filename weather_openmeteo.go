@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	openMeteoURL        = "https://api.open-meteo.com"
+	openMeteoGeocodeURL = "https://geocoding-api.open-meteo.com"
+)
+
+// wmoCodes describes WMO weather interpretation codes used by Open-Meteo
+var wmoCodes = map[string]map[int]string{
+	langRu: {
+		0:  "ясно",
+		1:  "преимущественно ясно",
+		2:  "переменная облачность",
+		3:  "пасмурно",
+		45: "туман",
+		48: "изморозь",
+		51: "слабая морось",
+		53: "морось",
+		55: "сильная морось",
+		56: "ледяная морось",
+		57: "сильная ледяная морось",
+		61: "небольшой дождь",
+		63: "дождь",
+		65: "сильный дождь",
+		66: "ледяной дождь",
+		67: "сильный ледяной дождь",
+		71: "небольшой снег",
+		73: "снег",
+		75: "сильный снег",
+		77: "снежные зёрна",
+		80: "небольшой ливень",
+		81: "ливень",
+		82: "сильный ливень",
+		85: "снегопад",
+		86: "сильный снегопад",
+		95: "гроза",
+		96: "гроза с градом",
+		99: "гроза с сильным градом",
+	},
+	langEn: {
+		0:  "clear sky",
+		1:  "mainly clear",
+		2:  "partly cloudy",
+		3:  "overcast",
+		45: "fog",
+		48: "rime fog",
+		51: "light drizzle",
+		53: "drizzle",
+		55: "dense drizzle",
+		56: "freezing drizzle",
+		57: "dense freezing drizzle",
+		61: "light rain",
+		63: "rain",
+		65: "heavy rain",
+		66: "freezing rain",
+		67: "heavy freezing rain",
+		71: "light snow",
+		73: "snow",
+		75: "heavy snow",
+		77: "snow grains",
+		80: "light showers",
+		81: "showers",
+		82: "violent showers",
+		85: "snow showers",
+		86: "heavy snow showers",
+		95: "thunderstorm",
+		96: "thunderstorm with hail",
+		99: "thunderstorm with heavy hail",
+	},
+}
+
+func wmoDescription(code int, lang string) string {
+	descriptions, ok := wmoCodes[lang]
+	if !ok {
+		descriptions = wmoCodes[defaultLang]
+	}
+	return descriptions[code]
+}
+
+// openMeteo is keyless and works with coordinates only,
+// city,country locations are geocoded first
+type openMeteo struct {
+	baseURL, geocodeURL, userAgent string
+}
+
+type openMeteoReply struct {
+	Current struct {
+		Temp          float64 `json:"temperature_2m"`
+		FeelsLike     float64 `json:"apparent_temperature"`
+		Humidity      int     `json:"relative_humidity_2m"`
+		Pressure      float64 `json:"pressure_msl"`
+		WindSpeed     float64 `json:"wind_speed_10m"`
+		WindDirection float64 `json:"wind_direction_10m"`
+		WeatherCode   int     `json:"weather_code"`
+	} `json:"current"`
+	Daily struct {
+		Time        []string  `json:"time"`
+		TempMax     []float64 `json:"temperature_2m_max"`
+		TempMin     []float64 `json:"temperature_2m_min"`
+		WeatherCode []int     `json:"weather_code"`
+		// percents
+		Pop     []float64 `json:"precipitation_probability_max"`
+		WindMax []float64 `json:"wind_speed_10m_max"`
+		// local time without zone
+		Sunrise []string `json:"sunrise"`
+		Sunset  []string `json:"sunset"`
+	} `json:"daily"`
+	UTCOffset int `json:"utc_offset_seconds"`
+}
+
+func (o *openMeteo) Name() string {
+	return "openmeteo"
+}
+
+func (o *openMeteo) resolve(ctx context.Context, loc location, lang string) (location, error) {
+	if loc.HasCoords {
+		return loc, nil
+	}
+	places, err := o.Geocode(ctx, loc.City, lang)
+	if err != nil {
+		return location{}, err
+	}
+	for _, place := range places {
+		if strings.EqualFold(place.Country, loc.Country) {
+			resolved := place.location()
+			// keep the name from the aliases table
+			resolved.City = loc.City
+			return resolved, nil
+		}
+	}
+	return location{}, errUnknownCity
+}
+
+func (o *openMeteo) fetch(ctx context.Context, loc location, query url.Values) (reply openMeteoReply, err error) {
+	query.Set("latitude", strconv.FormatFloat(loc.Lat, 'f', 4, 64))
+	query.Set("longitude", strconv.FormatFloat(loc.Lon, 'f', 4, 64))
+	query.Set("wind_speed_unit", "ms")
+	query.Set("timezone", "auto")
+	body, _, err := get(ctx, o.baseURL+"/v1/forecast?"+query.Encode(), "application/json", o.userAgent)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	err = json.NewDecoder(body).Decode(&reply)
+	return
+}
+
+func (o *openMeteo) Current(ctx context.Context, loc location, lang string) (weather, error) {
+	loc, err := o.resolve(ctx, loc, lang)
+	if err != nil {
+		return weather{}, err
+	}
+	reply, err := o.fetch(ctx, loc, url.Values{
+		"current":       {"temperature_2m,apparent_temperature,relative_humidity_2m,pressure_msl,wind_speed_10m,wind_direction_10m,weather_code"},
+		"daily":         {"temperature_2m_max,temperature_2m_min,sunrise,sunset"},
+		"forecast_days": {"1"},
+	})
+	if err != nil {
+		return weather{}, err
+	}
+	w := weather{
+		Place:       loc.City,
+		Country:     loc.Country,
+		Description: wmoDescription(reply.Current.WeatherCode, lang),
+		Temp:        reply.Current.Temp,
+		FeelsLike:   reply.Current.FeelsLike,
+		Pressure:    reply.Current.Pressure,
+		Humidity:    reply.Current.Humidity,
+		WindSpeed:   reply.Current.WindSpeed,
+		WindDeg:     reply.Current.WindDirection,
+		Provider:    o.Name(),
+	}
+	if len(reply.Daily.TempMin) > 0 && len(reply.Daily.TempMax) > 0 {
+		w.TempMin, w.TempMax = reply.Daily.TempMin[0], reply.Daily.TempMax[0]
+	}
+	if len(reply.Daily.Sunrise) > 0 && len(reply.Daily.Sunset) > 0 {
+		zone := time.FixedZone(loc.City, reply.UTCOffset)
+		w.Sunrise, _ = time.ParseInLocation("2006-01-02T15:04", reply.Daily.Sunrise[0], zone)
+		w.Sunset, _ = time.ParseInLocation("2006-01-02T15:04", reply.Daily.Sunset[0], zone)
+	}
+	return w, nil
+}
+
+func (o *openMeteo) Forecast(ctx context.Context, loc location, days int, lang string) (forecast, error) {
+	loc, err := o.resolve(ctx, loc, lang)
+	if err != nil {
+		return forecast{}, err
+	}
+	reply, err := o.fetch(ctx, loc, url.Values{
+		"daily":         {"temperature_2m_max,temperature_2m_min,weather_code,precipitation_probability_max,wind_speed_10m_max"},
+		"forecast_days": {strconv.Itoa(days)},
+	})
+	if err != nil {
+		return forecast{}, err
+	}
+	result := forecast{Place: loc.City, Country: loc.Country, Provider: o.Name()}
+	daily := reply.Daily
+	for i, day := range daily.Time {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil || i >= len(daily.TempMin) || i >= len(daily.TempMax) {
+			continue
+		}
+		fd := forecastDay{Date: date, Min: daily.TempMin[i], Max: daily.TempMax[i]}
+		if i < len(daily.WeatherCode) {
+			fd.Condition = wmoDescription(daily.WeatherCode[i], lang)
+		}
+		if i < len(daily.Pop) {
+			fd.Pop = daily.Pop[i] / 100
+		}
+		if i < len(daily.WindMax) {
+			fd.Wind = daily.WindMax[i]
+		}
+		result.Days = append(result.Days, fd)
+	}
+	return result, nil
+}
+
+func (o *openMeteo) Geocode(ctx context.Context, name, lang string) ([]geoPlace, error) {
+	var reply struct {
+		Results []struct {
+			Name        string  `json:"name"`
+			Latitude    float64 `json:"latitude"`
+			Longitude   float64 `json:"longitude"`
+			CountryCode string  `json:"country_code"`
+			Admin1      string  `json:"admin1"`
+		} `json:"results"`
+	}
+	// the search doesn't understand city,country
+	country := ""
+	if i := strings.LastIndex(name, ","); i != -1 {
+		name, country = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+	}
+	query := url.Values{
+		"name":     {name},
+		"count":    {"10"},
+		"language": {lang},
+		"format":   {"json"},
+	}
+	body, _, err := get(ctx, o.geocodeURL+"/v1/search?"+query.Encode(), "application/json", o.userAgent)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	if err = json.NewDecoder(body).Decode(&reply); err != nil {
+		return nil, err
+	}
+	var places []geoPlace
+	for _, result := range reply.Results {
+		if country != "" && !strings.EqualFold(result.CountryCode, country) {
+			continue
+		}
+		places = append(places, geoPlace{
+			Name:    result.Name,
+			State:   result.Admin1,
+			Country: result.CountryCode,
+			Lat:     result.Latitude,
+			Lon:     result.Longitude,
+		})
+	}
+	return dedupPlaces(places), nil
+}
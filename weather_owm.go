@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	openWeatherMapURL = "https://api.openweathermap.org"
+)
+
+type openWeatherMap struct {
+	baseURL, token, userAgent string
+}
+
+type owmWeather struct {
+	Weather []struct {
+		Description string `json:"description"`
+	} `json:"weather"`
+	Main struct {
+		Temp      float64 `json:"temp"`
+		FeelsLike float64 `json:"feels_like"`
+		Pressure  float64 `json:"pressure"`
+		Humidity  int     `json:"humidity"`
+		TempMin   float64 `json:"temp_min"`
+		TempMax   float64 `json:"temp_max"`
+	} `json:"main"`
+	Wind struct {
+		Speed float64 `json:"speed"`
+		Deg   float64 `json:"deg"`
+	} `json:"wind"`
+	Sys struct {
+		Country string `json:"country"`
+		Sunrise int64  `json:"sunrise"`
+		Sunset  int64  `json:"sunset"`
+	} `json:"sys"`
+	// shift from UTC in seconds
+	Timezone int    `json:"timezone"`
+	CityID   int    `json:"id"`
+	Name     string `json:"name"`
+	Cod      int    `json:"cod"`
+}
+
+type owmForecast struct {
+	List []struct {
+		Dt   int64 `json:"dt"`
+		Main struct {
+			TempMin float64 `json:"temp_min"`
+			TempMax float64 `json:"temp_max"`
+		} `json:"main"`
+		Weather []struct {
+			Description string `json:"description"`
+		} `json:"weather"`
+		Wind struct {
+			Speed float64 `json:"speed"`
+		} `json:"wind"`
+		// probability of precipitation, 0..1
+		Pop float64 `json:"pop"`
+	} `json:"list"`
+	City struct {
+		Name    string `json:"name"`
+		Country string `json:"country"`
+		// shift from UTC in seconds
+		Timezone int `json:"timezone"`
+	} `json:"city"`
+}
+
+func (o *openWeatherMap) Name() string {
+	return "openweathermap"
+}
+
+func (o *openWeatherMap) query(loc location, lang string) url.Values {
+	query := url.Values{
+		"units": {"metric"},
+		"lang":  {lang},
+		"appid": {o.token},
+	}
+	if loc.HasCoords {
+		query.Set("lat", strconv.FormatFloat(loc.Lat, 'f', 4, 64))
+		query.Set("lon", strconv.FormatFloat(loc.Lon, 'f', 4, 64))
+	} else {
+		query.Set("q", loc.City+","+loc.Country)
+	}
+	return query
+}
+
+func (o *openWeatherMap) fetch(ctx context.Context, path string, query url.Values, result interface{}) error {
+	if o.token == "" {
+		return errors.New("no OpenWeatherMap token")
+	}
+	body, _, err := get(ctx, o.baseURL+path+"?"+query.Encode(), "application/json", o.userAgent)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	return json.NewDecoder(body).Decode(result)
+}
+
+func (o *openWeatherMap) Current(ctx context.Context, loc location, lang string) (weather, error) {
+	var raw owmWeather
+	if err := o.fetch(ctx, "/data/2.5/weather", o.query(loc, lang), &raw); err != nil {
+		return weather{}, err
+	}
+	w := weather{
+		Place:     raw.Name,
+		Country:   raw.Sys.Country,
+		Temp:      raw.Main.Temp,
+		FeelsLike: raw.Main.FeelsLike,
+		Pressure:  raw.Main.Pressure,
+		Humidity:  raw.Main.Humidity,
+		WindSpeed: raw.Wind.Speed,
+		WindDeg:   raw.Wind.Deg,
+		TempMin:   raw.Main.TempMin,
+		TempMax:   raw.Main.TempMax,
+		Provider:  o.Name(),
+	}
+	if raw.Sys.Sunrise != 0 && raw.Sys.Sunset != 0 {
+		zone := time.FixedZone(raw.Name, raw.Timezone)
+		w.Sunrise = time.Unix(raw.Sys.Sunrise, 0).In(zone)
+		w.Sunset = time.Unix(raw.Sys.Sunset, 0).In(zone)
+	}
+	if len(raw.Weather) > 0 {
+		w.Description = raw.Weather[0].Description
+	}
+	return w, nil
+}
+
+// Forecast condenses 3 hour steps of the free forecast into days
+func (o *openWeatherMap) Forecast(ctx context.Context, loc location, days int, lang string) (forecast, error) {
+	var raw owmForecast
+	if err := o.fetch(ctx, "/data/2.5/forecast", o.query(loc, lang), &raw); err != nil {
+		return forecast{}, err
+	}
+	result := forecast{Place: raw.City.Name, Country: raw.City.Country, Provider: o.Name()}
+	zone := time.FixedZone(raw.City.Name, raw.City.Timezone)
+	var conditions []map[string]int
+	for _, step := range raw.List {
+		t := time.Unix(step.Dt, 0).In(zone)
+		date := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, zone)
+		if len(result.Days) == 0 || !result.Days[len(result.Days)-1].Date.Equal(date) {
+			result.Days = append(result.Days, forecastDay{
+				Date: date,
+				Min:  step.Main.TempMin,
+				Max:  step.Main.TempMax,
+			})
+			conditions = append(conditions, make(map[string]int))
+		}
+		day := &result.Days[len(result.Days)-1]
+		if step.Main.TempMin < day.Min {
+			day.Min = step.Main.TempMin
+		}
+		if step.Main.TempMax > day.Max {
+			day.Max = step.Main.TempMax
+		}
+		if step.Pop > day.Pop {
+			day.Pop = step.Pop
+		}
+		if step.Wind.Speed > day.Wind {
+			day.Wind = step.Wind.Speed
+		}
+		if len(step.Weather) > 0 {
+			conditions[len(conditions)-1][step.Weather[0].Description]++
+		}
+	}
+	for i := range result.Days {
+		result.Days[i].Condition = dominant(conditions[i])
+	}
+	if len(result.Days) > days {
+		result.Days = result.Days[:days]
+	}
+	return result, nil
+}
+
+// dominant returns the most frequent condition
+func dominant(conditions map[string]int) (condition string) {
+	best := 0
+	for description, count := range conditions {
+		if count > best || (count == best && description < condition) {
+			condition, best = description, count
+		}
+	}
+	return
+}
+
+func (o *openWeatherMap) Geocode(ctx context.Context, name, lang string) ([]geoPlace, error) {
+	var found []struct {
+		Name       string            `json:"name"`
+		LocalNames map[string]string `json:"local_names"`
+		Lat        float64           `json:"lat"`
+		Lon        float64           `json:"lon"`
+		Country    string            `json:"country"`
+		State      string            `json:"state"`
+	}
+	query := url.Values{"q": {name}, "limit": {"5"}, "appid": {o.token}}
+	if err := o.fetch(ctx, "/geo/1.0/direct", query, &found); err != nil {
+		return nil, err
+	}
+	places := make([]geoPlace, 0, len(found))
+	for _, place := range found {
+		name := place.Name
+		if local, ok := place.LocalNames[lang]; ok {
+			name = local
+		}
+		places = append(places, geoPlace{
+			Name:    name,
+			State:   place.State,
+			Country: place.Country,
+			Lat:     place.Lat,
+			Lon:     place.Lon,
+		})
+	}
+	return dedupPlaces(places), nil
+}